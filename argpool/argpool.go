@@ -24,6 +24,7 @@ package argpool
 import (
 	"fmt"
 	"math/big"
+	"math/rand"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -43,12 +44,76 @@ type ArgPool struct {
 	BigIntPool    *pool
 	StringPool    *stringpool
 	TimestampPool *timestamppool
+	// operands of comparisons for each method
+	CmpPool *cmppool
+	// source of randomness of the worker that owns the pool
+	Rand *rand.Rand
+	// block timestamp at the start of campaign, used while no timestamps
+	// were added to the pool
+	StartTime int64
+	// when set, values added to the pool are recorded so that they can be
+	// shared with pools of other workers
+	RecordAdded bool
+	added       []interface{}
+}
+
+// operand of comparison executed by method, recorded value of AddCmpValue
+type CmpValue struct {
+	Method string
+	Value  *big.Int
+}
+
+func (argPool *ArgPool) record(item interface{}) {
+	if argPool.RecordAdded {
+		argPool.added = append(argPool.added, item)
+	}
+}
+
+// returns values added since the last call and clears them
+func (argPool *ArgPool) TakeAdded() []interface{} {
+	added := argPool.added
+	argPool.added = nil
+	return added
+}
+
+// adds value recorded by pool of another worker, imported values are not
+// recorded again
+func (argPool *ArgPool) AddValue(item interface{}) {
+	recordAdded := argPool.RecordAdded
+	argPool.RecordAdded = false
+	defer func() { argPool.RecordAdded = recordAdded }()
+
+	switch value := item.(type) {
+	case int8:
+		argPool.AddInt8(value)
+	case int16:
+		argPool.AddInt16(value)
+	case int32:
+		argPool.AddInt32(value)
+	case int64:
+		argPool.AddInt64(value)
+	case [32]byte:
+		argPool.AddBytes32(value)
+	case common.Address:
+		argPool.AddAddress(value)
+	case *big.Int:
+		argPool.AddBigInt(new(big.Int).Set(value))
+	case string:
+		argPool.AddString(value)
+	case uint64:
+		argPool.AddTimestamp(value)
+	case CmpValue:
+		argPool.AddCmpValue(value.Method, new(big.Int).Set(value.Value))
+	default:
+		log.Warn(fmt.Sprintf("unknown type of shared value: %T", item))
+	}
 }
 
 func (argPool *ArgPool) AddInt64(item int64) {
 	if !argPool.Int64Pool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding int64: %+v", item))
 		argPool.Int64Pool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -56,6 +121,7 @@ func (argPool *ArgPool) AddInt32(item int32) {
 	if !argPool.Int32Pool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding int32: %+v", item))
 		argPool.Int32Pool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -63,6 +129,7 @@ func (argPool *ArgPool) AddInt16(item int16) {
 	if !argPool.Int16Pool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding int16: %+v", item))
 		argPool.Int16Pool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -70,6 +137,7 @@ func (argPool *ArgPool) AddInt8(item int8) {
 	if !argPool.Int8Pool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding int8: %+v", item))
 		argPool.Int8Pool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -77,6 +145,7 @@ func (argPool *ArgPool) AddBytes32(item [32]byte) {
 	if !argPool.Bytes32Pool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding bytes32: %+v", item))
 		argPool.Bytes32Pool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -84,6 +153,7 @@ func (argPool *ArgPool) AddAddress(item common.Address) {
 	if !argPool.AddressPool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding address: %+v", item))
 		argPool.AddressPool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -91,6 +161,7 @@ func (argPool *ArgPool) AddBigInt(item *big.Int) {
 	if !argPool.BigIntPool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding bigInt: %+v", item))
 		argPool.BigIntPool.Add(item)
+		argPool.record(new(big.Int).Set(item))
 	}
 }
 
@@ -98,6 +169,7 @@ func (argPool *ArgPool) AddString(item string) {
 	if !argPool.StringPool.Contains(item) {
 		log.Trace(fmt.Sprintf("adding string: %+v", item))
 		argPool.StringPool.Add(item)
+		argPool.record(item)
 	}
 }
 
@@ -106,9 +178,30 @@ func (argPool *ArgPool) AddTimestamp(item uint64) {
 		log.Trace(fmt.Sprintf("adding timestamp: %+v", item))
 		argPool.TimestampPool.Add(item)
 		argPool.TimestampPool.Sort()
+		argPool.record(item)
 	}
 }
 
+// adds operand of comparison executed by method, value is preferred when
+// generating arguments of the same method and is added to typed pools
+func (argPool *ArgPool) AddCmpValue(method string, item *big.Int) {
+	if argPool.CmpPool.Contains(method, item) {
+		return
+	}
+	log.Trace(fmt.Sprintf("adding comparison operand of %v: %+v", method, item))
+	argPool.CmpPool.Add(method, item)
+	argPool.record(CmpValue{Method: method, Value: new(big.Int).Set(item)})
+	argPool.AddBigInt(item)
+	if item.IsInt64() {
+		argPool.AddInt64(item.Int64())
+	}
+	// values of address size
+	if item.BitLen() > 128 && item.BitLen() <= 160 {
+		argPool.AddAddress(common.BigToAddress(item))
+	}
+	argPool.AddBytes32(common.BigToHash(item))
+}
+
 func (pool *ArgPool) NextInt64() int64 {
 	return pool.Int64Pool.Next()
 }
@@ -141,16 +234,27 @@ func (pool *ArgPool) NextString() string {
 	return pool.StringPool.Next()
 }
 
+// returns nil if no comparison operand was observed for method
+func (pool *ArgPool) NextCmpValue(method string) *big.Int {
+	return pool.CmpPool.Next(method)
+}
+
 func (pool *ArgPool) NextTimestamp() (*big.Int, bool) {
 	if pool.TimestampPool.Size() == 0 {
-		return big.NewInt(time.Now().Unix()), false
+		return big.NewInt(pool.StartTime), false
 	}
 	return pool.TimestampPool.Next(), pool.TimestampPool.AllPassed()
 }
 
+// returns timestamp that follows the given one without moving the current
+// timestamp, nil if there is no later timestamp
+func (pool *ArgPool) TimestampAfter(timestamp *big.Int) *big.Int {
+	return pool.TimestampPool.After(timestamp)
+}
+
 func (pool *ArgPool) CurrentTimestamp() *big.Int {
 	if pool.TimestampPool.Size() == 0 {
-		return big.NewInt(time.Now().Unix())
+		return big.NewInt(pool.StartTime)
 	}
 	return pool.TimestampPool.GetCurrent()
 }
@@ -165,6 +269,26 @@ func (pool *ArgPool) GetSizes() map[string]int {
 		"address":   pool.AddressPool.Size(),
 		"bigInt":    pool.BigIntPool.Size(),
 		"timestamp": pool.TimestampPool.Size(),
+		"cmp":       pool.CmpPool.Size(),
+	}
+}
+
+// returns new empty pool, each fuzzing worker has its own pool and random
+// number generator
+func NewArgPool(rng *rand.Rand, startTime int64) *ArgPool {
+	return &ArgPool{
+		Int8Pool:      GetInt8Pool(),
+		Int16Pool:     GetInt16Pool(),
+		Int32Pool:     GetInt32Pool(),
+		Int64Pool:     GetInt64Pool(),
+		Bytes32Pool:   GetBytes32Pool(),
+		AddressPool:   newPool(),
+		BigIntPool:    newPool(),
+		StringPool:    GetStringPool(),
+		TimestampPool: GetTimestampPool(),
+		CmpPool:       GetCmpPool(),
+		Rand:          rng,
+		StartTime:     startTime,
 	}
 }
 
@@ -181,6 +305,9 @@ func GetArgPool() *ArgPool {
 			BigIntPool:    GetPool("BigInt"),
 			StringPool:    GetStringPool(),
 			TimestampPool: GetTimestampPool(),
+			CmpPool:       GetCmpPool(),
+			Rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+			StartTime:     time.Now().Unix(),
 		}
 	}
 	return argPool
@@ -0,0 +1,89 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package argpool
+
+import (
+	"math/big"
+)
+
+// maximal number of values kept for one method
+const maxCmpValues = 1024
+
+// FIFO queue for each method, containing operands of comparisons that were
+// observed while executing the method, once a method has maxCmpValues
+// operands the oldest one is evicted for each new one
+type cmppool struct {
+	// Next() iterates circularly so we keep index of current element
+	idx map[string]int
+	// circular list of elements
+	storage map[string][]*big.Int
+	// map for checking whether pool already contains value, for deduplication
+	storageMap map[string]map[string]bool
+}
+
+func (p *cmppool) Add(method string, item *big.Int) {
+	if p.storageMap[method] == nil {
+		p.storageMap[method] = make(map[string]bool)
+	}
+	if len(p.storage[method]) >= maxCmpValues {
+		oldest := p.storage[method][0]
+		delete(p.storageMap[method], oldest.String())
+		p.storage[method] = p.storage[method][1:]
+		// keep Next() at the same element
+		if p.idx[method] > 0 {
+			p.idx[method]--
+		}
+	}
+	p.storage[method] = append(p.storage[method], item)
+	p.storageMap[method][item.String()] = true
+}
+
+func (p *cmppool) Next(method string) *big.Int {
+	storage := p.storage[method]
+	if len(storage) == 0 {
+		return nil
+	}
+	item := storage[p.idx[method]]
+	p.idx[method] = (p.idx[method] + 1) % len(storage)
+	return item
+}
+
+func (p *cmppool) Contains(method string, val *big.Int) bool {
+	_, ok := p.storageMap[method][val.String()]
+	return ok
+}
+
+func (p *cmppool) Size() int {
+	size := 0
+	for _, storage := range p.storage {
+		size += len(storage)
+	}
+	return size
+}
+
+func GetCmpPool() *cmppool {
+	return &cmppool{
+		idx:        make(map[string]int),
+		storage:    make(map[string][]*big.Int),
+		storageMap: make(map[string]map[string]bool),
+	}
+}
@@ -0,0 +1,121 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package argpool
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// values of all pools, saved in checkpoints of campaigns
+type Contents struct {
+	Int8       []int8                `json:"int8"`
+	Int16      []int16               `json:"int16"`
+	Int32      []int32               `json:"int32"`
+	Int64      []int64               `json:"int64"`
+	Bytes32    []common.Hash         `json:"bytes32"`
+	Addresses  []common.Address      `json:"addresses"`
+	BigInts    []*big.Int            `json:"bigInts"`
+	Strings    []string              `json:"strings"`
+	Timestamps []uint64              `json:"timestamps"`
+	Cmp        map[string][]*big.Int `json:"cmp"`
+}
+
+// returns copy of values in the pool
+func (argPool *ArgPool) Contents() *Contents {
+	contents := &Contents{
+		Int8:    append([]int8{}, argPool.Int8Pool.storage...),
+		Int16:   append([]int16{}, argPool.Int16Pool.storage...),
+		Int32:   append([]int32{}, argPool.Int32Pool.storage...),
+		Int64:   append([]int64{}, argPool.Int64Pool.storage...),
+		Strings: append([]string{}, argPool.StringPool.storage...),
+		Cmp:     make(map[string][]*big.Int),
+	}
+	for _, item := range argPool.Bytes32Pool.storage {
+		contents.Bytes32 = append(contents.Bytes32, common.Hash(item))
+	}
+	for _, item := range argPool.AddressPool.storage {
+		contents.Addresses = append(contents.Addresses, item.(common.Address))
+	}
+	for _, item := range argPool.BigIntPool.storage {
+		contents.BigInts = append(contents.BigInts, new(big.Int).Set(item.(*big.Int)))
+	}
+	for _, item := range argPool.TimestampPool.storage {
+		contents.Timestamps = append(contents.Timestamps, item.Uint64())
+	}
+	for method, items := range argPool.CmpPool.storage {
+		for _, item := range items {
+			contents.Cmp[method] = append(contents.Cmp[method], new(big.Int).Set(item))
+		}
+	}
+	return contents
+}
+
+// adds values of contents to the pool, values that are already in the pool
+// are skipped
+func (argPool *ArgPool) Restore(contents *Contents) {
+	var values []interface{}
+	for _, item := range contents.Int8 {
+		values = append(values, item)
+	}
+	for _, item := range contents.Int16 {
+		values = append(values, item)
+	}
+	for _, item := range contents.Int32 {
+		values = append(values, item)
+	}
+	for _, item := range contents.Int64 {
+		values = append(values, item)
+	}
+	for _, item := range contents.Bytes32 {
+		values = append(values, [32]byte(item))
+	}
+	for _, item := range contents.Addresses {
+		values = append(values, item)
+	}
+	for _, item := range contents.BigInts {
+		values = append(values, item)
+	}
+	for _, item := range contents.Strings {
+		values = append(values, item)
+	}
+	for _, item := range contents.Timestamps {
+		values = append(values, item)
+	}
+	// methods are sorted so that restored pool doesn't depend on map order
+	methods := make([]string, 0, len(contents.Cmp))
+	for method := range contents.Cmp {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		for _, item := range contents.Cmp[method] {
+			values = append(values, CmpValue{Method: method, Value: item})
+		}
+	}
+	for _, value := range values {
+		argPool.AddValue(value)
+	}
+}
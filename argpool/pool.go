@@ -60,13 +60,17 @@ func (p *pool) Size() int {
 	return len(p.storage)
 }
 
+func newPool() *pool {
+	return &pool{
+		idx:        0,
+		storage:    make([]interface{}, 0),
+		storageMap: make(map[string]bool),
+	}
+}
+
 func GetPool(id string) *pool {
 	if pools[id] == nil {
-		pools[id] = &pool{
-			idx:        0,
-			storage:    make([]interface{}, 0),
-			storageMap: make(map[string]bool),
-		}
+		pools[id] = newPool()
 	}
 	return pools[id]
 }
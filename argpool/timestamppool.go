@@ -56,6 +56,16 @@ func (p *timestamppool) GetCurrent() *big.Int {
 	return p.storage[p.idx]
 }
 
+// returns the first timestamp later than t, nil if t is the latest one
+func (p *timestamppool) After(t *big.Int) *big.Int {
+	for _, item := range p.storage {
+		if item.Cmp(t) > 0 {
+			return item
+		}
+	}
+	return nil
+}
+
 func (p *timestamppool) AllPassed() bool {
 	if len(p.storage) > 0 && (p.idx+1 == len(p.storage)) {
 		return true
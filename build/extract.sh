@@ -9,9 +9,11 @@ YELLOW='\033[1;33m'
 CYAN='\033[0;36m'
 
 display_usage() {
-	printf "${RED}You must provide path to truffle project that you want to deploy and extract transactions from${NC}\n"
+	printf "${RED}You must provide path to project that you want to deploy and extract transactions from${NC}\n"
 	printf "Usage:\n"
-	printf "\t-p, --path\tpath to the first version of truffle project\n"
+	printf "\t-p, --path\tpath to the project\n"
+	printf "\t-f, --framework\ttruffle (default), hardhat or foundry\n"
+	printf "\t-c, --command\tdeployment command (required for foundry), it must deploy to http://127.0.0.1:8545\n"
 	printf "\n\n"
 	printf "Example:\n\t./extract.sh -p /home/anodar/Desktop/thesis/MetaCoin\n"
 	printf "\n\n"
@@ -40,6 +42,16 @@ case $key in
     shift # past argument
     shift # past value
     ;;
+    -f|--framework)
+    FRAMEWORK="$2"
+    shift # past argument
+    shift # past value
+    ;;
+    -c|--command)
+    DEPLOY_CMD="$2"
+    shift # past argument
+    shift # past value
+    ;;
     --default)
     DEFAULT=YES
     shift # past argument
@@ -52,17 +64,36 @@ esac
 done
 set -- "${POSITIONAL[@]}" # restore positional parameters
 
-echo truffle directory        = "${TRUFFLE_DIR}"
+FRAMEWORK=${FRAMEWORK:-truffle}
+if [ -z "$DEPLOY_CMD" ]
+then
+	case $FRAMEWORK in
+		truffle)
+		DEPLOY_CMD="truffle deploy"
+		;;
+		hardhat)
+		DEPLOY_CMD="npx hardhat run scripts/deploy.js --network localhost"
+		;;
+		*)
+		printf "${RED}Please provide deployment command with -c for framework: ${NC}${FRAMEWORK}\n"
+		exit 1
+		;;
+	esac
+fi
+
+echo project directory        = "${TRUFFLE_DIR}"
+echo framework                = "${FRAMEWORK}"
 VERSION=$(echo ${TRUFFLE_DIR} | sha1sum  | awk '{print $1}')
-echo truffle project version  = "${VERSION}"
+echo project version          = "${VERSION}"
 
-kill_ganache_if_running() {
-	# kill ganache-cli if running (kills node TODO: somehow kill only ganache)
-	if pgrep -f /usr/bin/ganache-cli
+stop_rpc_server() {
+	if [ ! -z "$RPC_PID" ]
 	then
-		pkill -9 -f /usr/bin/ganache-cli
+		kill $RPC_PID 2> /dev/null || true
+		RPC_PID=""
 	fi
 }
+trap stop_rpc_server EXIT
 
 install_solc() {
 	# set specified solc version for truffle if present
@@ -76,39 +107,36 @@ install_solc() {
 }
 
 extract_transactions() {
-	extract_script=$PWD"/build/extract.js"
-
-	# move to truffle directory and deploy contracts
+	# move to project directory and deploy contracts, the fuzzer records
+	# deployment transactions into transactions file from metadata
 	cd ${TRUFFLE_DIR}
-	# convert truffle dir to absolute path
-	TRUFFLE_DIR=$PWD
-	printf "\n${YELLOW} deploying contracts on Ganache${NC}\n"
-	rm -rf build
-	truffle deploy # > /dev/null TODO
-	printf "\n${GREEN} contracts has been deployed on Ganache${NC}\n"
-	# execute transaction extraction javascript in truffle console
-	truffle compile
-	printf "\n${YELLOW} extracting transactions ${NC}\n"
-	truffle exec ${extract_script}
-	printf "\n${GREEN} transactions has been extracted${NC}\n"
-	# rename transactions file according to project VERSION
-	mv ${TRUFFLE_DIR}/transactions.json ${TRUFFLE_DIR}/fuzz_config/transactions_$VERSION.json
+	printf "\n${YELLOW} deploying contracts on the fuzzer's JSON-RPC server${NC}\n"
+	if [ "$FRAMEWORK" == "truffle" ]
+	then
+		rm -rf build
+	fi
+	${DEPLOY_CMD}
+	printf "\n${GREEN} contracts has been deployed, transactions has been recorded${NC}\n"
+	cd ${go_project_dir}
 }
 
-# check if truffle directories exist
+# check if project directories exist
 for i in "${TRUFFLE_DIR}"
 do
 	if [ ! -d $i ]; then
-	  printf "\n${RED}Truffle directory: ${NC}$i ${RED} doesn't exist ${NC}\n"
+	  printf "\n${RED}Project directory: ${NC}$i ${RED} doesn't exist ${NC}\n"
 	  exit 1
 	fi
 done
+# convert project dir to absolute path
+TRUFFLE_DIR=$(cd ${TRUFFLE_DIR} && pwd)
 
 mkdir -p build/gen
 mkdir -p ${TRUFFLE_DIR}/fuzz_config
 # write metadata in file
 metadata_JSON=${TRUFFLE_DIR}/fuzz_config/metadata_$VERSION.json
 metadata="{\n\t\"tuffleProjDir\": \"${TRUFFLE_DIR}\",
+\t\"framework\": \"${FRAMEWORK}\",
 \t\"transactions\": \"${TRUFFLE_DIR}/fuzz_config/transactions_${VERSION}.json\",
 \t\"accounts\": \"${TRUFFLE_DIR}/fuzz_config/accounts.json\",
 \t\"config\": \"${TRUFFLE_DIR}/fuzz_config/config.json\"
@@ -129,19 +157,27 @@ if [  -f "${TRUFFLE_DIR}/fuzz_config/transactions_${VERSION}.json" ]; then
 	rm "${TRUFFLE_DIR}/fuzz_config/transactions_${VERSION}.json"
 fi
 
-kill_ganache_if_running
-
 # install specified version of solc
 install_solc
 
 go_project_dir=$PWD
 
-# run ganache-cli on background
-python3 $PWD"/build/ganache.py" "${TRUFFLE_DIR}/fuzz_config/accounts.json" > /dev/null &
+# run the fuzzer's JSON-RPC server on background
+${go_project_dir}/build/bin/fuzzer --metadata ${metadata_JSON} serve-rpc --rpcport 8545 > /dev/null 2>&1 &
+RPC_PID=$!
+# wait until the server accepts connections
+for i in $(seq 1 50)
+do
+	if curl -s -H "Content-Type: application/json" -d '{"jsonrpc":"2.0","id":1,"method":"net_version","params":[]}' http://127.0.0.1:8545 > /dev/null
+	then
+		break
+	fi
+	sleep .2
+done
 
 extract_transactions
 
-kill_ganache_if_running
+stop_rpc_server
 
 printf "\t${CYAN}Project metadata was written to: ${GREEN}${metadata_JSON} ${NC}\n"
 sleep .5
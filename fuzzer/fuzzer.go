@@ -29,10 +29,12 @@ import (
 	"runtime/pprof"
 	"time"
 	"strings"
+	"sync"
 
 	"fuzzer/argpool"
 	"fuzzer/utils"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -50,7 +52,7 @@ var (
 	}
 	metadataFileFlag = cli.StringFlag{
 		Name:  "metadata",
-		Usage: "metadata file of truffle project to fuzz (generated from extract.sh script)",
+		Usage: "metadata file of project to fuzz (generated from extract.sh script)",
 		Value: "",
 	}
 	contractFlag = cli.StringFlag{
@@ -92,8 +94,53 @@ var (
 		Usage: "flag for profiling memory, specify filename to save profile",
 		Value: "",
 	}
+	workersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of workers fuzzing in parallel (each one fuzzes its own copy of contracts)",
+		Value: 1,
+	}
+	// campaign with the same seed and timestamp (and a single worker) is
+	// reproducible
+	seedFlag = cli.Int64Flag{
+		Name:  "seed",
+		Usage: "seed of random number generator (random seed is used if 0)",
+		Value: 0,
+	}
+	timestampFlag = cli.Int64Flag{
+		Name:  "timestamp",
+		Usage: "block timestamp at the start of campaign (current time is used if 0)",
+		Value: 0,
+	}
+	reportFlag = cli.StringFlag{
+		Name:  "report",
+		Usage: "write results (configuration, coverage and findings) as JSON to specified file",
+		Value: "",
+	}
+	sarifFlag = cli.StringFlag{
+		Name:  "sarif",
+		Usage: "write findings in SARIF format to specified file",
+		Value: "",
+	}
+	outFlag = cli.StringFlag{
+		Name:  "out",
+		Usage: "output directory, each campaign writes findings, traces, corpus and coverage into its own subdirectory",
+		Value: "/tmp/chainfuzz",
+	}
+	checkpointFlag = cli.IntFlag{
+		Name:  "checkpoint",
+		Usage: "interval in seconds in which workers save checkpoints to output directory (disabled if 0)",
+		Value: 600,
+	}
+	resumeFlag = cli.StringFlag{
+		Name:  "resume",
+		Usage: "directory of campaign to resume from its checkpoints, files are written to the same directory",
+		Value: "",
+	}
 )
 
+// number of sequences a worker executes between exchanges with other workers
+const syncInterval = 32
+
 type Flags struct {
 	Metadata    string
 	Contract    string
@@ -102,6 +149,17 @@ type Flags struct {
 	OptMode     *utils.OptMode
 	Accounts    int
 	LogLevel    int
+	Workers     int
+	Seed        int64
+	Timestamp   int64
+	// optimizations bitset as it was given
+	Optimizations int
+	Report        string
+	Sarif         string
+	Out           string
+	// seconds between checkpoints
+	Checkpoint int
+	Resume     string
 }
 
 func init() {
@@ -120,8 +178,20 @@ func init() {
 		logLevelFlag,
 		cpuProfileFlag,
 		memProfileFlag,
+		workersFlag,
+		seedFlag,
+		timestampFlag,
+		reportFlag,
+		sarifFlag,
+		outFlag,
+		checkpointFlag,
+		resumeFlag,
 	}
 	app.Action = run
+	app.Commands = []cli.Command{
+		replayCommand,
+		serveRPCCommand,
+	}
 }
 
 func getCLFlags(ctx *cli.Context) *Flags {
@@ -139,6 +209,19 @@ func getCLFlags(ctx *cli.Context) *Flags {
 	optMode := &utils.OptMode{}
 	optMode.SetFlag(optimizations)
 	accounts := ctx.GlobalInt(accountsFlag.Name)
+	workers := ctx.GlobalInt(workersFlag.Name)
+	if workers < 1 {
+		log.Error("number of workers should be at least 1")
+		os.Exit(1)
+	}
+	seed := ctx.GlobalInt64(seedFlag.Name)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	timestamp := ctx.GlobalInt64(timestampFlag.Name)
+	if timestamp == 0 {
+		timestamp = time.Now().Unix()
+	}
 
 	return &Flags{
 		Metadata:    metadata,
@@ -148,16 +231,73 @@ func getCLFlags(ctx *cli.Context) *Flags {
 		OptMode:     optMode,
 		Accounts:    accounts,
 		LogLevel:    ctx.Int(logLevelFlag.Name),
+		Workers:     workers,
+		Seed:        seed,
+		Timestamp:   timestamp,
+
+		Optimizations: optimizations,
+		Report:        ctx.GlobalString(reportFlag.Name),
+		Sarif:         ctx.GlobalString(sarifFlag.Name),
+		Out:           ctx.GlobalString(outFlag.Name),
+
+		Checkpoint: ctx.GlobalInt(checkpointFlag.Name),
+		Resume:     ctx.GlobalString(resumeFlag.Name),
 	}
 }
 
-func getCoverage(metadata string, contract string, backend *utils.Backend) (int, int) {
+// returns covered (by any of given instances) and total number of opcodes
+func getCoverage(metadata string, contract string, addresses []common.Address,
+	backend *utils.Backend) (int, int) {
 	indices := utils.GetOpcodeIndices(metadata, contract)
-	contractAddress := backend.DeployedContracts[contract].Addresses[0]
-	coveredIndices := backend.OpcodeIndices[contractAddress]
+	coveredIndices := make(map[uint64]bool)
+	for _, address := range addresses {
+		for pc := range backend.OpcodeIndices[backend.CodeAddress(address)] {
+			coveredIndices[pc] = true
+		}
+	}
 	return len(coveredIndices), len(indices)
 }
 
+// returns covered (by any of given instances) and total number of JUMPI
+// outcomes (taken/not taken)
+func getBranchCoverage(metadata string, contract string, addresses []common.Address,
+	backend *utils.Backend) (int, int) {
+	indices := utils.GetBranchIndices(metadata, contract)
+	coveredBranches := make(map[utils.Branch]bool)
+	for _, address := range addresses {
+		for branch := range backend.BranchIndices[backend.CodeAddress(address)] {
+			coveredBranches[branch] = true
+		}
+	}
+	return len(coveredBranches), 2 * len(indices)
+}
+
+// adds opcode and branch coverage of instances to result under given suffix
+func addCoverage(result utils.ResultsMap, metadata string, contract string,
+	suffix string, addresses []common.Address, backend *utils.Backend) {
+	covered, total := getCoverage(metadata, contract, addresses, backend)
+	if total > 0 {
+		result[contract]["covered"+suffix] = fmt.Sprintf("%v/%v, %v%%", covered, total, 100.0*covered/total)
+	}
+	covered, total = getBranchCoverage(metadata, contract, addresses, backend)
+	if total > 0 {
+		result[contract]["branches"+suffix] = fmt.Sprintf("%v/%v, %v%%", covered, total, 100.0*covered/total)
+	}
+}
+
+// returns coverage of contract (union of its instances) for the report
+func newContractReport(metadata string, contract string, addresses []common.Address,
+	backend *utils.Backend) *utils.ContractReport {
+	report := &utils.ContractReport{
+		Name:      contract,
+		Source:    utils.GetSourcePath(metadata, contract),
+		Instances: addresses,
+	}
+	report.Instructions.Covered, report.Instructions.Total = getCoverage(metadata, contract, addresses, backend)
+	report.Branches.Covered, report.Branches.Total = getBranchCoverage(metadata, contract, addresses, backend)
+	return report
+}
+
 // resets all global variables that are used for caching
 // only useful when testing several projects at the same time
 func Reset() {
@@ -165,73 +305,185 @@ func Reset() {
 	argpool.ResetArgPool()
 }
 
+// executes sequences on worker until limit of transactions (executed by all
+// workers) is reached or some worker terminates fuzzing
+func fuzzWorker(w *utils.Worker, exchange *utils.Exchange, flags *Flags,
+	options *utils.Options) {
+	sequences := 0
+	checkpoint := time.Now()
+	for exchange.TxCount() < flags.Limit && !exchange.Stopped() {
+		txCount := w.Backend.TxCount
+		terminate := utils.FuzzSequence(w.Backend, w.ArgPool, w.Corpus, options, w.Result, flags.OptMode)
+		total := exchange.AddTxCount(w.Backend.TxCount - txCount)
+		if terminate {
+			exchange.Stop()
+			break
+		}
+
+		sequences++
+		if flags.Workers > 1 && sequences%syncInterval == 0 {
+			w.Sync(exchange)
+		}
+		if flags.Checkpoint > 0 && time.Since(checkpoint) >= time.Duration(flags.Checkpoint)*time.Second {
+			w.SaveCheckpoint()
+			checkpoint = time.Now()
+		}
+		// progress is printed by the first worker only
+		if w.ID == 0 && flags.LogLevel <= int(log.LvlInfo) {
+			fmt.Printf("\rTransactions:  %v/%v, %v%%, corpus: %v", total, flags.Limit,
+				100.0*total/flags.Limit, w.Corpus.Size(),
+			)
+		}
+	}
+}
+
 func fuzz(ctx *cli.Context) {
 	flags := getCLFlags(ctx)
-	argPool := argpool.GetArgPool()
-	backend := utils.NewBackend(flags.Metadata, argPool)
-	result := make(utils.ResultsMap)
-	utils.SnapshotBackend(backend)
-
+	// workers are created one after another, project data is loaded and
+	// cached while the first one is created
+	log.Info(fmt.Sprintf("Seed: %v, timestamp: %v", flags.Seed, flags.Timestamp))
 	// start timer
 	start := time.Now()
+	campaignDir := flags.Resume
+	if campaignDir == "" {
+		campaignDir = utils.NewCampaignDir(flags.Out, start, flags.Seed)
+	} else if _, err := os.Stat(campaignDir); err != nil {
+		log.Error(fmt.Sprintf("campaign directory %v doesn't exist", campaignDir))
+		os.Exit(1)
+	}
+	log.Info(fmt.Sprintf("Output directory: %v", campaignDir))
+
+	workers := make([]*utils.Worker, flags.Workers)
+	for i := range workers {
+		workers[i] = utils.NewWorker(i, flags.Metadata, flags.Seed+int64(i), flags.Timestamp)
+		workers[i].Backend.OutDir = campaignDir
+		// values found by one worker are shared with others
+		workers[i].ArgPool.RecordAdded = flags.Workers > 1
+	}
+	backend, argPool, result := workers[0].Backend, workers[0].ArgPool, workers[0].Result
+
+	// transactions of resumed campaign count towards the limit, findings of
+	// resumed campaign are not saved again
+	exchange := utils.NewExchange(flags.Workers)
+	resumed := 0
+	if flags.Resume != "" {
+		restored := utils.ResumeWorkers(campaignDir, workers)
+		if restored == 0 {
+			log.Warn(fmt.Sprintf("No checkpoints found in %v, campaign starts from scratch", campaignDir))
+		}
+		for _, w := range workers {
+			resumed = exchange.AddTxCount(w.Backend.TxCount)
+		}
+	}
+	// each finding is saved by one worker, findings restored from checkpoints
+	// were already saved
+	for _, w := range workers {
+		w.Backend.Exchange = exchange
+		for _, saved := range w.Backend.Findings {
+			exchange.ClaimFinding(saved.Key())
+		}
+	}
+	txCount := backend.TxCount
 
 	options := &utils.Options{
 		UpdateCoverage:        true,
 		CheckDeployedContract: false,
 		UpdateArgPool:         true,
+		DiscoverContracts:     true,
 	}
 
-	// initial transactions to cover corner cases:
+	// initial transactions to cover corner cases (executed by first worker):
 	// - fallbacks for all contracts
 	// - paying non-payable methods
-	for contract, _ := range backend.DeployedContracts {
+	for _, contract := range utils.GetDeployedContractNames(backend) {
 		if contract == "Migrations" {
 			continue
 		}
-		hint := &utils.Hint{Contract: contract, Fallback: true}
-		utils.Rec(backend, argPool, hint, options, result, flags.OptMode, 0)
-		for _, method := range backend.DeployedContracts[contract].Methods {
-			// send ether to all un-payable function except fuzz_always_true
-			if !utils.IsPayable(contract, method) && strings.Index(method, "fuzz_always_true") != 0 {
-				hint = &utils.Hint{Contract: contract, Method: method, Amount: big.NewInt(1)}
-				utils.Rec(backend, argPool, hint, options, result, flags.OptMode, 0)
+		for _, address := range backend.DeployedContracts[contract].Addresses {
+			address := address
+			hint := &utils.Hint{Contract: contract, Fallback: true, Address: &address}
+			utils.Rec(backend, argPool, hint, options, result, flags.OptMode, 0)
+			for _, method := range backend.DeployedContracts[contract].Methods {
+				// send ether to all un-payable function except fuzz_always_true
+				if !utils.IsPayable(contract, method) && strings.Index(method, "fuzz_always_true") != 0 {
+					hint = &utils.Hint{Contract: contract, Method: method, Amount: big.NewInt(1), Address: &address}
+					utils.Rec(backend, argPool, hint, options, result, flags.OptMode, 0)
+				}
 			}
 		}
 	}
 
-	backend.TxCount = 0
-	// increase limit by number of deployment transactions
-	flags.Limit = flags.Limit + backend.TxCount
-	for i := backend.TxCount; i < flags.Limit; i++ {
-		// ADVICED NOT TO SPECIFY HINT FOR ALL TRANSACTIONS (in Hint obj)
-		// even when you only want to test specific contract extracted values
-		// from other contracts are important for fuzzing main contract
-		hint := &utils.Hint{}
-		terminate := utils.Rec(backend, argPool, hint, options, result, flags.OptMode, 0)
-		if terminate || backend.TxCount > flags.Limit {
-			break
-		}
-		if flags.OptMode.SnapshotsEnabled && (i&8192 != 0) {
-			utils.RevertBackend(backend)
+	// corner cases are not counted
+	backend.TxCount = txCount
+	// sequences of transactions are executed from the snapshot state and
+	// derived from the corpus of sequences that increased coverage, workers
+	// periodically exchange their corpus entries and arg pool values
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *utils.Worker) {
+			defer wg.Done()
+			fuzzWorker(w, exchange, flags, options)
+		}(w)
+	}
+	wg.Wait()
+	fmt.Println()
+	// first worker collects corpus entries that were not exchanged yet
+	if flags.Workers > 1 {
+		for _, w := range workers[1:] {
+			w.Sync(exchange)
 		}
-
-		if flags.LogLevel <= int(log.LvlInfo) {
-			fmt.Printf("\rTransactions:  %v/%v, %v%%", backend.TxCount, flags.Limit, 100.0*backend.TxCount/flags.Limit)
+		workers[0].Sync(exchange)
+	}
+	// final state of workers, campaign can be resumed with a higher limit
+	if flags.Checkpoint > 0 {
+		for _, w := range workers {
+			w.SaveCheckpoint()
 		}
 	}
-	fmt.Println()
 
-	// stop timer, and check coverage
+	// stop timer, and check coverage of all workers
 	elapsed := time.Since(start)
+	backend = utils.MergeWorkers(workers).Backend
+	report := utils.NewReport(app.Version, utils.CampaignConfig{
+		Metadata:      flags.Metadata,
+		Contract:      flags.Contract,
+		Limit:         flags.Limit,
+		Optimizations: flags.Optimizations,
+		Workers:       flags.Workers,
+		Seed:          flags.Seed,
+		Timestamp:     flags.Timestamp,
+	}, backend, elapsed.Seconds())
+	// rate of this run only
+	report.Rate = float64(backend.TxCount-resumed) / elapsed.Seconds()
 	log.Info("Calculating coverage. (needs to read and analyse bytecode opcodes)")
-	for contract, _ := range backend.DeployedContracts {
+	for _, contract := range utils.GetDeployedContractNames(backend) {
 		if result[contract] == nil {
 			continue
 		}
 
-		covered, total := getCoverage(flags.Metadata, contract, backend)
-		s := fmt.Sprintf("%v/%v, %v%%", covered, total, 100.0*covered/total)
-		result[contract]["covered"] = s
+		// coverage of contract type is the union of coverage of its instances
+		addresses := backend.DeployedContracts[contract].Addresses
+		addCoverage(result, flags.Metadata, contract, "", addresses, backend)
+		report.Contracts = append(report.Contracts, newContractReport(flags.Metadata, contract, addresses, backend))
+		if len(addresses) < 2 {
+			continue
+		}
+		for _, address := range addresses {
+			suffix := fmt.Sprintf(" (%v)", address.Hex())
+			addCoverage(result, flags.Metadata, contract, suffix, []common.Address{address}, backend)
+		}
+	}
+
+	// ether flows are observed by all workers during the whole campaign
+	for _, locked := range report.LockedEther {
+		if result[locked.Contract] == nil {
+			result[locked.Contract] = make(map[string]string)
+		}
+		result[locked.Contract][utils.LockedEtherKind] = locked.String()
+		log.Warn(fmt.Sprintf("%v in %v: accepted ether was never observed to leave (%v)",
+			utils.LockedEtherKind, locked.Contract, locked,
+		))
 	}
 
 	log.Info(fmt.Sprintf("Fuzzing result: %+v", utils.PrettyPrint(result)))
@@ -240,8 +492,32 @@ func fuzz(ctx *cli.Context) {
 		log.Info(fmt.Sprintf("Stats: %+v", utils.PrettyPrint(backend.Stats.GetStats())))
 	}
 
+	if resumed > 0 {
+		log.Info(fmt.Sprintf("resumed campaign: %v tx before this run", resumed))
+	}
 	log.Info(fmt.Sprintf("fuzzed: %v tx in %.2fsec. (rate: %.2f tx/s)",
-		backend.TxCount, elapsed.Seconds(), float64(backend.TxCount)/elapsed.Seconds(),
+		backend.TxCount-resumed, elapsed.Seconds(), report.Rate,
+	))
+	log.Info(fmt.Sprintf("reproduce with: --seed %v --timestamp %v --workers %v",
+		flags.Seed, flags.Timestamp, flags.Workers,
+	))
+
+	corpusFiles := utils.SaveCorpus(campaignDir, workers[0].Corpus)
+	coverageFiles := utils.SaveCoverage(campaignDir, backend, report.Contracts)
+	utils.WriteManifest(campaignDir, start, report, corpusFiles, coverageFiles)
+
+	if flags.Report != "" {
+		utils.WriteReport(flags.Report, report)
+	}
+	if flags.Sarif != "" {
+		utils.WriteSARIF(flags.Sarif, report)
+	}
+}
+
+func setLogHandler(ctx *cli.Context) {
+	log.Root().SetHandler(log.LvlFilterHandler(
+		log.Lvl(ctx.GlobalInt(logLevelFlag.Name)),
+		log.StreamHandler(os.Stderr, log.TerminalFormat(true)),
 	))
 }
 
@@ -258,10 +534,7 @@ func run(ctx *cli.Context) error {
 		defer pprof.StopCPUProfile()
 	}
 
-	log.Root().SetHandler(log.LvlFilterHandler(
-		log.Lvl(ctx.Int(logLevelFlag.Name)),
-		log.StreamHandler(os.Stderr, log.TerminalFormat(true)),
-	))
+	setLogHandler(ctx)
 	fuzz(ctx)
 
 	memprofile := ctx.GlobalString(memProfileFlag.Name)
@@ -0,0 +1,123 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package main
+
+import (
+	"fmt"
+
+	"fuzzer/argpool"
+	"fuzzer/utils"
+
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	sequenceFileFlag = cli.StringFlag{
+		Name:  "sequence",
+		Usage: "sequence file of a violation (findings/<key>.json in campaign directory) to replay",
+		Value: "",
+	}
+	replayCommand = cli.Command{
+		Name:  "replay",
+		Usage: "Re-executes saved sequence of transactions on freshly deployed contracts",
+		Flags: []cli.Flag{
+			sequenceFileFlag,
+		},
+		Action: replay,
+	}
+)
+
+// summary of replayed transaction that is printed for every call
+type replayedTx struct {
+	Contract         string        `json:"contract"`
+	Method           string        `json:"method"`
+	Output           []interface{} `json:"output"`
+	RevertAtDepth    int           `json:"revertAtDepth"`
+	AssertionAtDepth int           `json:"assertionAtDepth"`
+	Overflow         string        `json:"overflow"`
+	Violations       []string      `json:"violations"`
+}
+
+// replays sequence of transactions and exits with code 1 if the violation of
+// the finding reproduces on the last transaction, or with code 2 if the
+// sequence can't be loaded or replayed
+func replay(ctx *cli.Context) error {
+	setLogHandler(ctx)
+	metadata := ctx.GlobalString(metadataFileFlag.Name)
+	if metadata == "" {
+		return cli.NewExitError("please provide metadata file with --metadata option", 2)
+	}
+	sequenceFile := ctx.String(sequenceFileFlag.Name)
+	if sequenceFile == "" {
+		return cli.NewExitError("please provide sequence file with --sequence option", 2)
+	}
+
+	argPool := argpool.GetArgPool()
+	backend := utils.NewBackend(metadata, argPool)
+	finding, err := utils.LoadFinding(sequenceFile, metadata)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 2)
+	}
+	utils.SnapshotBackend(backend)
+	options := &utils.Options{}
+
+	reproduced := false
+	for i, input := range finding.Transactions {
+		if err := utils.ReplayTransaction(backend, nil, input, options); err != nil {
+			return cli.NewExitError(fmt.Sprintf("transaction %v could not be applied: %v", i+1, err), 2)
+		}
+		res := backend.LastTxRes
+		summary := replayedTx{
+			Contract:         input.Contract,
+			Method:           input.Method,
+			RevertAtDepth:    res.RevertAtDepth,
+			AssertionAtDepth: res.AssertionAtDepth,
+			Overflow:         res.Overflow,
+			Violations:       make([]string, 0),
+		}
+		if res.RevertAtDepth != 1 && len(res.Output) > 0 {
+			values, err := input.OutArgs.UnpackValues(res.Output)
+			if err != nil {
+				log.Warn(fmt.Sprintf("Error unpacking returned arguments: %+v", err))
+			}
+			summary.Output = values
+		}
+		for _, violation := range utils.GetViolations(backend) {
+			summary.Violations = append(summary.Violations, violation.Kind)
+			if i == len(finding.Transactions)-1 && finding.Matches(input, violation) {
+				reproduced = true
+			}
+		}
+		log.Info(fmt.Sprintf("%v. %v", i+1, utils.PrettyPrint(summary)))
+	}
+
+	if reproduced {
+		return cli.NewExitError(fmt.Sprintf("%v in %v.%v%v reproduced", finding.Kind,
+			finding.Contract, finding.Method, finding.SourceSuffix(),
+		), 1)
+	}
+	log.Info(fmt.Sprintf("%v in %v.%v%v did not reproduce", finding.Kind,
+		finding.Contract, finding.Method, finding.SourceSuffix(),
+	))
+	return nil
+}
@@ -0,0 +1,75 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"fuzzer/utils"
+
+	"github.com/ethereum/go-ethereum/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	rpcAddrFlag = cli.StringFlag{
+		Name:  "rpcaddr",
+		Usage: "listening interface of the JSON-RPC server",
+		Value: "127.0.0.1",
+	}
+	rpcPortFlag = cli.IntFlag{
+		Name:  "rpcport",
+		Usage: "listening port of the JSON-RPC server",
+		Value: 8545,
+	}
+	serveRPCCommand = cli.Command{
+		Name:  "serve-rpc",
+		Usage: "Serves JSON-RPC endpoint to deploy the project and records deployment transactions",
+		Flags: []cli.Flag{
+			rpcAddrFlag,
+			rpcPortFlag,
+		},
+		Action: serveRPC,
+	}
+)
+
+// starts JSON-RPC server backed by the fuzzer's backend, every applied
+// transaction is written to the transactions file from metadata
+func serveRPC(ctx *cli.Context) error {
+	setLogHandler(ctx)
+	metadata := ctx.GlobalString(metadataFileFlag.Name)
+	if metadata == "" {
+		log.Error("please provide metadata file with --metadata option")
+		os.Exit(1)
+	}
+	backend := utils.NewEmptyBackend(metadata)
+	server := utils.NewRPCServer(backend)
+	endpoint := fmt.Sprintf("%v:%v", ctx.String(rpcAddrFlag.Name), ctx.Int(rpcPortFlag.Name))
+	log.Info(fmt.Sprintf("JSON-RPC server listening on http://%v", endpoint))
+	if err := http.ListenAndServe(endpoint, server); err != nil {
+		return cli.NewExitError(fmt.Sprintf("JSON-RPC server failed: %v", err), 1)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// logs searched back from SSTORE for arithmetic on the stored value
+const maxArithmeticDistance = 64
+
+type storageKey struct {
+	address common.Address
+	slot    common.Hash
+}
+
+// mapping key and base slot, slot of mapping entry is keccak256(key . base)
+type preimage struct {
+	key  common.Hash
+	base common.Hash
+}
+
+// first write of a slot in a transaction
+type storageWrite struct {
+	storageKey
+	location *Location
+	// stored value was computed by ADD or SUB after the slot was loaded,
+	// e.g. counter was incremented
+	arithmetic bool
+}
+
+// Tracks storage reads and writes of a transaction, values of slots before
+// the transaction are known if they were loaded before they were written,
+// otherwise values at the start of the sequence are used.
+type accessTracker struct {
+	backend   *Backend
+	loaded    map[storageKey]common.Hash
+	written   map[storageKey]bool
+	writes    []*storageWrite
+	preimages map[common.Hash]preimage
+}
+
+func newAccessTracker(backend *Backend) *accessTracker {
+	return &accessTracker{
+		backend:   backend,
+		loaded:    make(map[storageKey]common.Hash),
+		written:   make(map[storageKey]bool),
+		preimages: make(map[common.Hash]preimage),
+	}
+}
+
+// returns n bytes of memory at offset, memory is extended with zeros
+func memorySlice(memory []byte, offset uint64, n uint64) []byte {
+	res := make([]byte, n)
+	if offset < uint64(len(memory)) {
+		copy(res, memory[offset:])
+	}
+	return res
+}
+
+// called for each log of code that is not in creation, context is the
+// address whose storage is accessed
+func (t *accessTracker) Step(structLogs []vm.StructLog, idx int, context common.Address, location *Location) {
+	structLog := structLogs[idx]
+	st := structLog.Stack
+	// result of SLOAD and SHA3 is on top of the stack of the next log
+	var result *big.Int
+	if idx < len(structLogs)-1 && structLogs[idx+1].Depth == structLog.Depth {
+		if next := structLogs[idx+1].Stack; len(next) > 0 {
+			result = next[len(next)-1]
+		}
+	}
+	switch structLog.Op {
+	case vm.SHA3:
+		offset, size := st[len(st)-1], st[len(st)-2]
+		if result == nil || !offset.IsUint64() || size.Cmp(big.NewInt(64)) != 0 {
+			return
+		}
+		data := memorySlice(structLog.Memory, offset.Uint64(), 64)
+		t.preimages[common.BigToHash(result)] = preimage{
+			key:  common.BytesToHash(data[:32]),
+			base: common.BytesToHash(data[32:]),
+		}
+	case vm.SLOAD:
+		key := storageKey{context, common.BigToHash(st[len(st)-1])}
+		if _, found := t.loaded[key]; !found && !t.written[key] && result != nil {
+			t.loaded[key] = common.BigToHash(result)
+		}
+	case vm.SSTORE:
+		key := storageKey{context, common.BigToHash(st[len(st)-1])}
+		if t.written[key] {
+			return
+		}
+		t.written[key] = true
+		t.writes = append(t.writes, &storageWrite{
+			storageKey: key,
+			location:   location,
+			arithmetic: isArithmetic(structLogs, idx, key.slot),
+		})
+	}
+}
+
+// checks if ADD or SUB was executed in the frame of SSTORE at idx since slot
+// was loaded
+func isArithmetic(structLogs []vm.StructLog, idx int, slot common.Hash) bool {
+	depth := structLogs[idx].Depth
+	for i := idx - 1; i >= 0 && i >= idx-maxArithmeticDistance; i-- {
+		structLog := structLogs[i]
+		if structLog.Depth != depth {
+			continue
+		}
+		switch structLog.Op {
+		case vm.ADD, vm.SUB:
+			return true
+		case vm.SLOAD:
+			if common.BigToHash(structLog.Stack[len(structLog.Stack)-1]) == slot {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// returns address stored in word, false if word is not an address
+func wordAddress(word common.Hash) (common.Address, bool) {
+	for _, b := range word[:12] {
+		if b != 0 {
+			return common.Address{}, false
+		}
+	}
+	address := common.BytesToAddress(word[12:])
+	return address, address != (common.Address{})
+}
+
+// returns addresses used as keys of (nested) mappings to compute slot
+func (t *accessTracker) mappingKeys(slot common.Hash) []common.Address {
+	var keys []common.Address
+	for i := 0; i < 8; i++ {
+		pre, found := t.preimages[slot]
+		if !found {
+			break
+		}
+		if address, ok := wordAddress(pre.key); ok {
+			keys = append(keys, address)
+		}
+		slot = pre.base
+	}
+	return keys
+}
+
+// returns whether account was used during deployment
+func (b *Backend) isDeployer(address common.Address) bool {
+	for _, account := range ReadAccounts(b.Metadata) {
+		if account.Address == address {
+			return account.Used
+		}
+	}
+	return false
+}
+
+// accounts used during deployment and accounts they granted access to in
+// the current sequence are privileged
+func (b *Backend) isPrivileged(address common.Address) bool {
+	if b.isDeployer(address) {
+		return true
+	}
+	for account, grantees := range b.Grants {
+		if grantees[address] && b.isDeployer(account) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records accounts to which sender of successful transaction granted access:
+// accounts of fuzzer it stored and used as mapping keys of slots it wrote
+// (e.g. new owner, approved spender, member of role).
+func (t *accessTracker) recordGrants(sender common.Address) {
+	b := t.backend
+	if b.Grants == nil {
+		b.Grants = make(map[common.Address]map[common.Address]bool)
+	}
+	if b.Grants[sender] == nil {
+		b.Grants[sender] = make(map[common.Address]bool)
+	}
+	for _, write := range t.writes {
+		grantees := t.mappingKeys(write.slot)
+		if address, ok := wordAddress(b.StateDB.GetState(write.address, write.slot)); ok {
+			grantees = append(grantees, address)
+		}
+		for _, address := range grantees {
+			if address != sender && (b.isDeployer(address) || b.isUnprivileged(address)) {
+				b.Grants[sender][address] = true
+			}
+		}
+	}
+}
+
+// returns description of privileged-looking state changed by write, empty if
+// the write is not suspicious
+func (t *accessTracker) checkWrite(write *storageWrite, sender common.Address) string {
+	b := t.backend
+	before, found := t.loaded[write.storageKey]
+	if !found {
+		before = b.snapshot.GetState(write.address, write.slot)
+	}
+	after := b.StateDB.GetState(write.address, write.slot)
+	if before == after {
+		return ""
+	}
+	// slot holding address of privileged account, e.g. owner (packed
+	// together with other variables, e.g. paused flag)
+	if owner := common.BytesToAddress(before[12:]); b.isPrivileged(owner) {
+		return fmt.Sprintf("slot %v of %v holding privileged %v changed to %v",
+			write.slot.Hex(), write.address.Hex(), owner.Hex(), after.Hex(),
+		)
+	}
+	// entry of (nested) mapping of another account decreased, e.g. balance
+	// or role, unless the account granted access to sender
+	keys := t.mappingKeys(write.slot)
+	if after.Big().Cmp(before.Big()) < 0 {
+		for _, key := range keys {
+			if key != sender && !b.Grants[key][sender] && (b.isDeployer(key) || b.isUnprivileged(key)) {
+				return fmt.Sprintf("entry %v of %v for %v decreased from %v to %v",
+					write.slot.Hex(), write.address.Hex(), key.Hex(), before.Big(), after.Big(),
+				)
+			}
+		}
+	}
+	// boolean flag that is not a mapping entry or a counter, e.g. paused,
+	// solidity loads slot of flag to mask the other variables in it
+	one := common.BigToHash(big.NewInt(1))
+	isBool := func(word common.Hash) bool { return word == common.Hash{} || word == one }
+	if found && len(keys) == 0 && !write.arithmetic && isBool(before) && isBool(after) {
+		return fmt.Sprintf("flag %v of %v changed from %v to %v",
+			write.slot.Hex(), write.address.Hex(), before.Big(), after.Big(),
+		)
+	}
+	return ""
+}
+
+// Checks writes of the transaction if it was successful and sent by an
+// unprivileged account (or through an attacker contract) to a method that
+// is not public according to config. Records grants of the sender.
+func (t *accessTracker) Check(receipt *types.Receipt) (string, *Location) {
+	b := t.backend
+	input := b.LastTxIn
+	if input == nil || input.Sender == nil || b.snapshot == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		return "", nil
+	}
+	sender := *input.caller()
+	defer t.recordGrants(sender)
+	if b.isPrivileged(sender) || isPublicMethod(b.Metadata, input.Contract, input.Method) ||
+		strings.Index(input.Method, "fuzz_always_true") == 0 {
+		return "", nil
+	}
+	for _, write := range t.writes {
+		if b.isAttacker(write.address) {
+			continue
+		}
+		if description := t.checkWrite(write, sender); description != "" {
+			return description, write.location
+		}
+	}
+	return "", nil
+}
@@ -118,9 +118,9 @@ func ReadAccounts(metadata string) []Account {
 	return accounts
 }
 
-func GetRandAccount(metadata string) Account {
+func GetRandAccount(metadata string, rng *rand.Rand) Account {
 	n := len(ReadAccounts(metadata))
-	return ReadAccounts(metadata)[rand.Intn(n)]
+	return ReadAccounts(metadata)[rng.Intn(n)]
 }
 
 func init() {
@@ -25,7 +25,6 @@ import (
 	"fmt"
 	"math"
 	"math/big"
-	"math/rand"
 	"reflect"
 
 	"fuzzer/argpool"
@@ -35,10 +34,48 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 )
 
-func fillRecursively(T reflect.Type, argPool *argpool.ArgPool) reflect.Value {
+// returns key of method in comparison operands pool
+func methodKey(contract, method string) string {
+	return fmt.Sprintf("%v.%v", contract, method)
+}
+
+// converts operand of comparison observed while executing method to type T,
+// returns invalid value if there is none (or randomly to keep using pools)
+func cmpValue(T reflect.Type, argPool *argpool.ArgPool, method string) reflect.Value {
+	isInteger := T.Kind() >= reflect.Int8 && T.Kind() <= reflect.Int64 ||
+		T.Kind() >= reflect.Uint8 && T.Kind() <= reflect.Uint64
+	if !isInteger && T != BigIntType && T != AddressType && T != Bytes32Type {
+		return reflect.Value{}
+	}
+	if argPool.Rand.Int()%2 == 0 {
+		return reflect.Value{}
+	}
+	val := argPool.NextCmpValue(method)
+	if val == nil {
+		return reflect.Value{}
+	}
+	switch T {
+	case BigIntType:
+		return reflect.ValueOf(new(big.Int).Set(val))
+	case AddressType:
+		return reflect.ValueOf(common.BigToAddress(val))
+	case Bytes32Type:
+		return reflect.ValueOf([32]byte(common.BigToHash(val)))
+	}
+	// conversion truncates to the size of integer type
+	low := new(big.Int).And(val, new(big.Int).SetUint64(math.MaxUint64))
+	return reflect.ValueOf(low.Uint64()).Convert(T)
+}
+
+func fillRecursively(T reflect.Type, argPool *argpool.ArgPool, method string) reflect.Value {
 	var ret reflect.Value
 	ret = reflect.New(T).Elem()
 
+	// prefer operands of comparisons observed in the same method
+	if val := cmpValue(T, argPool, method); val.IsValid() {
+		return val
+	}
+
 	switch T {
 	case Int8Type:
 		return reflect.ValueOf(argPool.NextInt8())
@@ -63,7 +100,7 @@ func fillRecursively(T reflect.Type, argPool *argpool.ArgPool) reflect.Value {
 	case StringType:
 		return reflect.ValueOf(argPool.NextString())
 	case BoolType:
-		x := rand.Int() % 2
+		x := argPool.Rand.Int() % 2
 		if x == 0 {
 			reflect.ValueOf(true)
 		}
@@ -74,15 +111,15 @@ func fillRecursively(T reflect.Type, argPool *argpool.ArgPool) reflect.Value {
 
 	if T.Kind() == reflect.Array {
 		for i := 0; i < T.Len(); i++ {
-			val := fillRecursively(ret.Index(i).Type(), argPool)
+			val := fillRecursively(ret.Index(i).Type(), argPool, method)
 			ret.Index(i).Set(val)
 		}
 		return ret
 	}
 	if T.Kind() == reflect.Slice {
-		len := (rand.Int() & 15) + 1
+		len := (argPool.Rand.Int() & 15) + 1
 		for i := 0; i < len; i++ {
-			val := fillRecursively(T.Elem(), argPool)
+			val := fillRecursively(T.Elem(), argPool, method)
 			ret = reflect.Append(ret, val)
 		}
 		return ret
@@ -138,10 +175,11 @@ func updateRecursively(val reflect.Value, argPool *argpool.ArgPool) {
 }
 
 // Constructs arguments for function from ABI recursively
-func ConstructArgs(args []abi.Argument, argPool *argpool.ArgPool) []interface{} {
+// (method is key of the method, see methodKey)
+func ConstructArgs(args []abi.Argument, argPool *argpool.ArgPool, method string) []interface{} {
 	var out []interface{}
 	for _, arg := range args {
-		val := fillRecursively(arg.Type.Type, argPool)
+		val := fillRecursively(arg.Type.Type, argPool, method)
 		out = append(out, val.Interface())
 	}
 	return out
@@ -177,7 +215,7 @@ func InitArgPool(argPool *argpool.ArgPool, metadata string) {
 	for cnt := 0; cnt < 10; cnt++ {
 		byteArr := [32]byte{}
 		for i := 0; i < 32; i++ {
-			byteArr[i] = byte(rand.Int() % 256)
+			byteArr[i] = byte(argPool.Rand.Int() % 256)
 		}
 		argPool.AddBytes32(byteArr)
 	}
@@ -0,0 +1,382 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// compiled contract read from build artifacts of the project
+type Artifact struct {
+	Name string
+	ABI  json.RawMessage
+	// runtime code as hex string without 0x prefix,
+	// may contain placeholders of linked libraries
+	DeployedBytecode string
+	// AST of the source unit that defines the contract (may be nil)
+	AST     interface{}
+	Library bool
+	// payable[method], transaction without method (key "") is payable if
+	// receive or fallback function is
+	Payable map[string]bool
+	// sorted names of payable methods, "receive" and "fallback" for receive
+	// and fallback functions
+	PayableEntries []string
+	// source map of runtime code (may be empty)
+	DeployedSourceMap string
+	// path and content of the source file that defines the contract, its
+	// index is used in source maps of contracts compiled together with it
+	// (-1 if unknown)
+	SourcePath string
+	Source     string
+	SourceID   int
+	// contracts compiled together share indices of source files
+	Compilation string
+}
+
+// reads artifacts of the compiled contracts of a project
+type ArtifactLoader interface {
+	LoadArtifacts(projectDir string) []*Artifact
+}
+
+var (
+	artifactLoaders = map[string]ArtifactLoader{
+		"truffle": truffleLoader{},
+		"hardhat": hardhatLoader{},
+		"foundry": foundryLoader{},
+	}
+	// loaded artifacts for each metadata file
+	loadedArtifacts map[string][]*Artifact
+	// runtime code of libraries starts with: PUSH20 <address> ADDRESS EQ
+	libraryCodePrefix = fmt.Sprintf("73%040x3014", 0)
+)
+
+// returns artifacts of the project described by metadata file,
+// the framework is selected with "framework" field (truffle by default)
+func GetArtifacts(metadata string) []*Artifact {
+	if loadedArtifacts == nil {
+		loadedArtifacts = make(map[string][]*Artifact)
+	}
+	if loadedArtifacts[metadata] != nil {
+		return loadedArtifacts[metadata]
+	}
+	framework := getFramework(metadata)
+	loader, found := artifactLoaders[framework]
+	if !found {
+		panic(fmt.Errorf("unsupported framework: %v\n", framework))
+	}
+	artifacts := loader.LoadArtifacts(getProjectDir(metadata))
+	// contracts are identified by name, keep the first one if names clash
+	names := make(map[string]bool)
+	for _, artifact := range artifacts {
+		if names[artifact.Name] {
+			log.Debug(fmt.Sprintf("ignoring duplicate artifact of contract: %v", artifact.Name))
+			continue
+		}
+		names[artifact.Name] = true
+		loadedArtifacts[metadata] = append(loadedArtifacts[metadata], artifact)
+	}
+	return loadedArtifacts[metadata]
+}
+
+// abi entries, only fields needed to tell if method is payable
+type abiEntry struct {
+	Type            string `json:"type"`
+	Name            string `json:"name"`
+	Payable         bool   `json:"payable"`
+	StateMutability string `json:"stateMutability"`
+}
+
+func newArtifact(name string, abiJSON json.RawMessage, deployedBytecode string,
+	ast interface{}) *Artifact {
+	var entries []abiEntry
+	if err := json.Unmarshal(abiJSON, &entries); err != nil {
+		panic(fmt.Errorf("Error processing contract ABI of %v: %+v\n", name, err))
+	}
+	payable := make(map[string]bool)
+	var payableEntries []string
+	for _, entry := range entries {
+		isPayable := entry.Payable || entry.StateMutability == "payable"
+		switch entry.Type {
+		case "constructor", "event", "error":
+			continue
+		case "receive", "fallback":
+			// both have empty name, transaction without method executes
+			// receive (if call data is empty) or fallback
+			payable[""] = payable[""] || isPayable
+			if isPayable {
+				payableEntries = append(payableEntries, entry.Type)
+			}
+		default:
+			payable[entry.Name] = isPayable
+			if isPayable {
+				payableEntries = append(payableEntries, entry.Name)
+			}
+		}
+	}
+	sort.Strings(payableEntries)
+	deployedBytecode = strings.TrimPrefix(deployedBytecode, "0x")
+	sourceUnit, _ := ast.(map[string]interface{})
+	sourcePath, _ := sourceUnit["absolutePath"].(string)
+	return &Artifact{
+		Name:             name,
+		ABI:              abiJSON,
+		DeployedBytecode: deployedBytecode,
+		AST:              ast,
+		Library:          isLibrary(name, ast, deployedBytecode),
+		Payable:          payable,
+		PayableEntries:   payableEntries,
+		// truffle 5 prefixes sources of the project
+		SourcePath: strings.TrimPrefix(sourcePath, "project:/"),
+		SourceID:   getSourceID(sourceUnit),
+	}
+}
+
+// returns index of source file from location of source unit ("start:length:index")
+func getSourceID(sourceUnit map[string]interface{}) int {
+	src, _ := sourceUnit["src"].(string)
+	parts := strings.Split(src, ":")
+	if len(parts) != 3 {
+		return -1
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// contract is a library if it's defined as one in the AST, if AST
+// is missing library is recognized by the prefix of its runtime code
+func isLibrary(name string, ast interface{}, deployedBytecode string) bool {
+	if sourceUnit, ok := ast.(map[string]interface{}); ok {
+		nodes, _ := sourceUnit["nodes"].([]interface{})
+		for _, node := range nodes {
+			n, _ := node.(map[string]interface{})
+			if n["nodeType"] == "ContractDefinition" && n["name"] == name {
+				return n["contractKind"] == "library"
+			}
+		}
+	}
+	return strings.HasPrefix(deployedBytecode, libraryCodePrefix)
+}
+
+// returns json files in dir and its subdirectories, build-info
+// folders and hardhat debug files are skipped
+func findArtifactFiles(dir string) []string {
+	var res []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == "build-info" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) == ".json" && !strings.HasSuffix(path, ".dbg.json") {
+			res = append(res, path)
+		}
+		return nil
+	})
+	if err != nil {
+		panic(fmt.Errorf("artifacts folder doesn't exist: %+v\n", err))
+	}
+	return res
+}
+
+func readArtifactFile(path string, v interface{}) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf("Error reading artifact file: %+v\n", err))
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		panic(fmt.Errorf("Error processing artifact %v: %+v\n", path, err))
+	}
+}
+
+// truffle: build/contracts/<Contract>.json
+type truffleLoader struct{}
+
+func (truffleLoader) LoadArtifacts(projectDir string) []*Artifact {
+	var res []*Artifact
+	for _, path := range findArtifactFiles(filepath.Join(projectDir, "build", "contracts")) {
+		var artifact struct {
+			ContractName      string          `json:"contractName"`
+			ABI               json.RawMessage `json:"abi"`
+			DeployedBytecode  string          `json:"deployedBytecode"`
+			DeployedSourceMap string          `json:"deployedSourceMap"`
+			Source            string          `json:"source"`
+			AST               interface{}     `json:"ast"`
+		}
+		readArtifactFile(path, &artifact)
+		a := newArtifact(getFilename(filepath.Base(path)),
+			artifact.ABI, artifact.DeployedBytecode, artifact.AST,
+		)
+		a.DeployedSourceMap = artifact.DeployedSourceMap
+		a.Source = artifact.Source
+		res = append(res, a)
+	}
+	return res
+}
+
+// hardhat: artifacts/<source>/<Contract>.json, ASTs are stored in build-info
+// files referenced by <Contract>.dbg.json
+type hardhatLoader struct{}
+
+func (hardhatLoader) LoadArtifacts(projectDir string) []*Artifact {
+	var res []*Artifact
+	buildInfos := make(map[string]*hardhatBuildInfo)
+	for _, path := range findArtifactFiles(filepath.Join(projectDir, "artifacts")) {
+		var artifact struct {
+			Format           string          `json:"_format"`
+			ContractName     string          `json:"contractName"`
+			SourceName       string          `json:"sourceName"`
+			ABI              json.RawMessage `json:"abi"`
+			DeployedBytecode string          `json:"deployedBytecode"`
+		}
+		readArtifactFile(path, &artifact)
+		if !strings.HasPrefix(artifact.Format, "hh-sol-artifact") {
+			continue
+		}
+		buildInfo, info := getHardhatBuildInfo(path, buildInfos)
+		if info == nil {
+			res = append(res, newArtifact(artifact.ContractName, artifact.ABI,
+				artifact.DeployedBytecode, nil,
+			))
+			continue
+		}
+		a := newArtifact(artifact.ContractName, artifact.ABI,
+			artifact.DeployedBytecode, info.Output.Sources[artifact.SourceName].AST,
+		)
+		a.Source = info.Input.Sources[artifact.SourceName].Content
+		contract := info.Output.Contracts[artifact.SourceName][artifact.ContractName]
+		a.DeployedSourceMap = contract.EVM.DeployedBytecode.SourceMap
+		a.Compilation = buildInfo
+		res = append(res, a)
+	}
+	return res
+}
+
+// compilation of hardhat project, only fields needed for artifacts
+type hardhatBuildInfo struct {
+	Input struct {
+		Sources map[string]struct {
+			Content string `json:"content"`
+		} `json:"sources"`
+	} `json:"input"`
+	Output struct {
+		Sources map[string]struct {
+			AST interface{} `json:"ast"`
+		} `json:"sources"`
+		Contracts map[string]map[string]struct {
+			EVM struct {
+				DeployedBytecode struct {
+					SourceMap string `json:"sourceMap"`
+				} `json:"deployedBytecode"`
+			} `json:"evm"`
+		} `json:"contracts"`
+	} `json:"output"`
+}
+
+// returns path and content of build-info file referenced by <Contract>.dbg.json
+// next to the artifact, nil if there is none
+func getHardhatBuildInfo(path string,
+	buildInfos map[string]*hardhatBuildInfo) (string, *hardhatBuildInfo) {
+	dbgFile := strings.TrimSuffix(path, ".json") + ".dbg.json"
+	if _, err := os.Stat(dbgFile); err != nil {
+		return "", nil
+	}
+	var dbg struct {
+		BuildInfo string `json:"buildInfo"`
+	}
+	readArtifactFile(dbgFile, &dbg)
+	buildInfo := filepath.Join(filepath.Dir(dbgFile), dbg.BuildInfo)
+	if buildInfos[buildInfo] == nil {
+		info := &hardhatBuildInfo{}
+		readArtifactFile(buildInfo, info)
+		buildInfos[buildInfo] = info
+	}
+	return buildInfo, buildInfos[buildInfo]
+}
+
+// foundry: out/<File>.sol/<Contract>.json
+type foundryLoader struct{}
+
+func (foundryLoader) LoadArtifacts(projectDir string) []*Artifact {
+	var res []*Artifact
+	for _, path := range findArtifactFiles(filepath.Join(projectDir, "out")) {
+		var artifact struct {
+			ABI              json.RawMessage `json:"abi"`
+			DeployedBytecode struct {
+				Object    string `json:"object"`
+				SourceMap string `json:"sourceMap"`
+			} `json:"deployedBytecode"`
+			AST interface{} `json:"ast"`
+		}
+		readArtifactFile(path, &artifact)
+		if len(artifact.ABI) == 0 || bytes.Equal(artifact.ABI, []byte("null")) {
+			continue
+		}
+		a := newArtifact(getFilename(filepath.Base(path)),
+			artifact.ABI, artifact.DeployedBytecode.Object, artifact.AST,
+		)
+		a.DeployedSourceMap = artifact.DeployedBytecode.SourceMap
+		// sources are not stored in artifacts, paths are relative to project
+		if content, err := ioutil.ReadFile(filepath.Join(projectDir, a.SourcePath)); err == nil {
+			a.Source = string(content)
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+// returns artifact of contract, nil if there is none
+func getArtifact(metadata string, contract string) *Artifact {
+	for _, artifact := range GetArtifacts(metadata) {
+		if artifact.Name == contract {
+			return artifact
+		}
+	}
+	return nil
+}
+
+// returns path of the source file that defines contract, as it is stored in
+// AST of the artifact (empty if AST is missing)
+func GetSourcePath(metadata string, contract string) string {
+	if artifact := getArtifact(metadata, contract); artifact != nil {
+		return artifact.SourcePath
+	}
+	return ""
+}
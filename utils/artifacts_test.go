@@ -0,0 +1,78 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewArtifactPayable(t *testing.T) {
+	tests := []struct {
+		name    string
+		abi     string
+		payable map[string]bool
+		entries []string
+	}{
+		{
+			"payable constructor",
+			`[{"type":"constructor","stateMutability":"payable","inputs":[]},
+			  {"type":"fallback","stateMutability":"nonpayable"}]`,
+			map[string]bool{"": false},
+			nil,
+		},
+		{
+			"receive before fallback",
+			`[{"type":"receive","stateMutability":"payable"},
+			  {"type":"fallback","stateMutability":"nonpayable"}]`,
+			map[string]bool{"": true},
+			[]string{"receive"},
+		},
+		{
+			"fallback before receive",
+			`[{"type":"fallback","stateMutability":"nonpayable"},
+			  {"type":"receive","stateMutability":"payable"}]`,
+			map[string]bool{"": true},
+			[]string{"receive"},
+		},
+		{
+			// solc < 0.6 ABI without stateMutability, type defaults to function
+			"legacy",
+			`[{"name":"deposit","payable":true,"inputs":[]},
+			  {"type":"function","name":"withdraw","payable":false,"inputs":[]},
+			  {"type":"fallback","payable":true},
+			  {"type":"event","name":"","inputs":[],"anonymous":true}]`,
+			map[string]bool{"deposit": true, "withdraw": false, "": true},
+			[]string{"deposit", "fallback"},
+		},
+	}
+	for _, test := range tests {
+		artifact := newArtifact("A", json.RawMessage(test.abi), "0x00", nil)
+		if !reflect.DeepEqual(artifact.Payable, test.payable) {
+			t.Errorf("%v: got payable %v, want %v", test.name, artifact.Payable, test.payable)
+		}
+		if !reflect.DeepEqual(artifact.PayableEntries, test.entries) {
+			t.Errorf("%v: got entries %v, want %v", test.name, artifact.PayableEntries, test.entries)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// number of attacker contracts put into the state of each backend
+const numAttackers = 2
+
+// Runtime code of attacker contract. Called by the account that sent the
+// transaction (CALLER == ORIGIN) it forwards the call data after the first
+// word to the address in the first word, with the same value, and returns
+// or reverts with its result. Called by a contract (ether transfer, token
+// hooks...) it calls back the caller once per transaction with call data
+// stored in its storage and returns the selector it was called with, as
+// expected by ERC721 and ERC1155 receivers. Calls with less than 10000 gas
+// (transfer/send) are only accepted.
+//
+// storage: slot 0 guard (set when the caller was called back), slot 1 length
+// of call data, slots 2.. words of call data
+//
+//	00: CALLER ORIGIN EQ PUSH2 0x0079 JUMPI
+//	07: PUSH2 0x2710 GAS LT PUSH2 0x004b JUMPI
+//	10: PUSH1 0 SLOAD PUSH2 0x004b JUMPI
+//	17: PUSH1 1 PUSH1 0 SSTORE PUSH1 0
+//	1e: JUMPDEST PUSH1 1 SLOAD DUP2 LT ISZERO PUSH2 0x003a JUMPI
+//	29: PUSH1 0x20 DUP2 DIV PUSH1 2 ADD SLOAD DUP2 MSTORE
+//	33: PUSH1 0x20 ADD PUSH2 0x001e JUMP
+//	3a: JUMPDEST POP PUSH1 0 PUSH1 0 PUSH1 1 SLOAD PUSH1 0 PUSH1 0 CALLER GAS CALL POP
+//	4b: JUMPDEST PUSH1 0 CALLDATALOAD PUSH32 0xffffffff00..00 AND
+//	71: PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 RETURN
+//	79: JUMPDEST PUSH1 0x20 CALLDATASIZE SUB
+//	7e: DUP1 PUSH1 0x20 PUSH1 0 CALLDATACOPY
+//	84: PUSH1 0 PUSH1 0 DUP3 PUSH1 0 CALLVALUE PUSH1 0 CALLDATALOAD GAS CALL
+//	91: RETURNDATASIZE PUSH1 0 PUSH1 0 RETURNDATACOPY PUSH2 0x009f JUMPI
+//	9b: RETURNDATASIZE PUSH1 0 REVERT
+//	9f: JUMPDEST RETURNDATASIZE PUSH1 0 RETURN
+const attackerCode = "333214610079576127105a1061004b5760005461004b57600160005560005b" +
+	"60015481101561003a576020810460020154815260200161001e565b5060006000" +
+	"60015460006000335af1505b6000357fffffffff0000000000000000000000000000" +
+	"00000000000000000000000000001660005260206000f35b602036038060206000" +
+	"3760006000826000346000355af13d600060003e61009f573d6000fd5b3d6000f3"
+
+var (
+	attackerGuardSlot  = common.BigToHash(big.NewInt(0))
+	attackerLengthSlot = common.BigToHash(big.NewInt(1))
+)
+
+// Runtime code of ERC1820 registry that only implements
+// getInterfaceImplementer(address,bytes32), it is put into the state if the
+// deployment didn't deploy the registry. Implementers are stored as in the
+// registry, in mapping (address => interface hash => implementer) in slot 0.
+//
+//	00: PUSH1 0 CALLDATALOAD PUSH29 0x0100..00 SWAP1 DIV
+//	23: PUSH4 0xaabbb8ca EQ PUSH1 0x2d JUMPI STOP
+//	2d: JUMPDEST PUSH1 4 CALLDATALOAD PUSH1 0 MSTORE PUSH1 0 PUSH1 0x20 MSTORE
+//	39: PUSH1 0x40 PUSH1 0 SHA3 PUSH1 0x20 MSTORE
+//	41: PUSH1 0x24 CALLDATALOAD PUSH1 0 MSTORE PUSH1 0x40 PUSH1 0 SHA3 SLOAD
+//	4d: PUSH1 0 MSTORE PUSH1 0x20 PUSH1 0 RETURN
+const erc1820Code = "6000357c01000000000000000000000000000000000000000000000000" +
+	"00000000900463aabbb8ca14602d57005b600435600052600060205260" +
+	"4060002060205260243560005260406000205460005260206000f3"
+
+var (
+	erc1820Address = common.HexToAddress("0x1820a4B7618BdE71Dce8cdc73aAB6C95905faD24")
+	// ERC777 tokens call hooks of senders and recipients registered for these
+	// interfaces
+	erc777Interfaces = []common.Hash{
+		crypto.Keccak256Hash([]byte("ERC777TokensSender")),
+		crypto.Keccak256Hash([]byte("ERC777TokensRecipient")),
+	}
+)
+
+func attackerAddress(idx int) common.Address {
+	return common.HexToAddress(fmt.Sprintf("0xa77ac0%034x", idx+1))
+}
+
+// puts attacker contracts into the state, they are used as senders of
+// transactions and as address arguments
+func (b *Backend) deployAttackers(argPool *argpool.ArgPool) {
+	code := common.FromHex(attackerCode)
+	for i := 0; i < numAttackers; i++ {
+		address := attackerAddress(i)
+		b.StateDB.SetCode(address, code)
+		b.Attackers = append(b.Attackers, address)
+		argPool.AddAddress(address)
+	}
+	b.registerAttackers()
+}
+
+// registers attackers as their own ERC777 hooks in ERC1820 registry, so that
+// ERC777 tokens call them back when they send or receive tokens
+func (b *Backend) registerAttackers() {
+	if b.StateDB.GetCodeSize(erc1820Address) == 0 {
+		b.StateDB.SetCode(erc1820Address, common.FromHex(erc1820Code))
+	}
+	for _, attacker := range b.Attackers {
+		implementers := crypto.Keccak256(common.LeftPadBytes(attacker.Bytes(), 32), make([]byte, 32))
+		for _, iface := range erc777Interfaces {
+			slot := crypto.Keccak256Hash(iface.Bytes(), implementers)
+			b.StateDB.SetState(erc1820Address, slot, common.BytesToHash(attacker.Bytes()))
+		}
+	}
+}
+
+func (b *Backend) isAttacker(address common.Address) bool {
+	for _, attacker := range b.Attackers {
+		if attacker == address {
+			return true
+		}
+	}
+	return false
+}
+
+// returns sender of the call to the contract, the attacker contract if the
+// transaction is sent through one
+func (input *LastTxInput) caller() *common.Address {
+	if input.Via != nil {
+		return input.Via
+	}
+	return input.Sender
+}
+
+// stores call data with which attackers call back into the calling contract
+// and resets their guards, so that each transaction may re-enter once
+func (b *Backend) armAttackers(reentry []byte) {
+	for _, attacker := range b.Attackers {
+		b.StateDB.SetState(attacker, attackerGuardSlot, common.Hash{})
+		b.StateDB.SetState(attacker, attackerLengthSlot, common.BigToHash(big.NewInt(int64(len(reentry)))))
+		for i := 0; i < len(reentry); i += 32 {
+			word := make([]byte, 32)
+			copy(word, reentry[i:])
+			slot := common.BigToHash(big.NewInt(int64(2 + i/32)))
+			b.StateDB.SetState(attacker, slot, common.BytesToHash(word))
+		}
+	}
+}
+
+// returns random method call of contract with which attackers re-enter it
+func genReentry(backend *Backend, argPool *argpool.ArgPool, contract string) (string, hexutil.Bytes) {
+	deployed := backend.DeployedContracts[contract]
+	if len(backend.Attackers) == 0 || deployed == nil || len(deployed.Methods) == 0 {
+		return "", nil
+	}
+	method := GetRandomMethod(contract, backend)
+	methodABI := GetContractMethod(contract, method, backend.Metadata)
+	args := ConstructArgs(methodABI.Inputs, argPool, methodKey(contract, method))
+	return method, GetCallBytecode(contract, method, args, backend.Metadata)
+}
+
+// call of attacker back into the contract that called it
+type reentry struct {
+	// storage context of re-entered contract
+	target common.Address
+	// depth of re-entered call, outer call of target is two levels above
+	depth int
+	// storage slots written by target in re-entered call, kept only if the
+	// re-entered call succeeded
+	writes   map[common.Hash]bool
+	returned bool
+}
+
+// Tracks storage contexts of calls in a trace (DELEGATECALL and CALLCODE
+// execute in the context of their caller) and calls of attackers back into
+// their callers. Reentrancy is found if a successful re-entered call writes a
+// storage slot that is written again by the outer call of the same contract
+// after the re-entered call returned.
+type reentrancyTracker struct {
+	backend   *Backend
+	contexts  []common.Address
+	reentries []*reentry
+	// first write of outer call to a slot written in re-entered call
+	Description string
+	Location    *Location
+}
+
+func newReentrancyTracker(backend *Backend, to *common.Address) *reentrancyTracker {
+	t := &reentrancyTracker{backend: backend}
+	if to != nil {
+		t.contexts = append(t.contexts, *to)
+	} else {
+		t.contexts = append(t.contexts, common.Address{})
+	}
+	return t
+}
+
+// called when code at address (executing at depth) is called by op, callSt
+// doesn't contain the callee yet
+func (t *reentrancyTracker) Enter(op vm.OpCode, callSt *callStack, address common.Address, depth int) {
+	caller := t.contexts[len(t.contexts)-1]
+	context := address
+	switch op {
+	case vm.DELEGATECALL, vm.CALLCODE:
+		context = caller
+	case vm.CREATE, vm.CREATE2:
+		context = common.Address{}
+	}
+	// attacker calls back the contract that called it
+	if len(t.contexts) > 1 && !callSt.InCreation() && t.backend.isAttacker(*callSt.Top()) &&
+		address == t.contexts[len(t.contexts)-2] && op == vm.CALL {
+		t.reentries = append(t.reentries, &reentry{
+			target: address,
+			depth:  depth,
+			writes: make(map[common.Hash]bool),
+		})
+	}
+	t.contexts = append(t.contexts, context)
+}
+
+// returns address whose storage is accessed by the executing code
+func (t *reentrancyTracker) Context() common.Address {
+	return t.contexts[len(t.contexts)-1]
+}
+
+// called with the first log after a call returned
+func (t *reentrancyTracker) Return(structLog vm.StructLog) {
+	t.contexts = t.contexts[:len(t.contexts)-1]
+	for _, r := range t.reentries {
+		if r.returned || structLog.Depth != r.depth-1 {
+			continue
+		}
+		r.returned = true
+		// result of CALL is on top of the stack
+		st := structLog.Stack
+		if len(st) == 0 || st[len(st)-1].Sign() == 0 {
+			r.writes = nil
+		}
+	}
+}
+
+// called for SSTORE instructions
+func (t *reentrancyTracker) Store(structLog vm.StructLog, location *Location) {
+	if t.Description != "" {
+		return
+	}
+	context := t.contexts[len(t.contexts)-1]
+	slot := common.BigToHash(structLog.Stack[len(structLog.Stack)-1])
+	for _, r := range t.reentries {
+		if r.target != context {
+			continue
+		}
+		if !r.returned && structLog.Depth >= r.depth {
+			r.writes[slot] = true
+		} else if r.returned && structLog.Depth <= r.depth-2 && r.writes[slot] {
+			t.Description = fmt.Sprintf("slot %v of %v written by re-entered call and afterwards by the outer call",
+				slot.Hex(), r.target.Hex(),
+			)
+			t.Location = location
+			return
+		}
+	}
+}
@@ -23,13 +23,17 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"math/big"
+	"math/rand"
 	"os"
+	"time"
 
 	"fuzzer/argpool"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/state"
@@ -52,6 +56,31 @@ type LastTxInput struct {
 	Input    *[]interface{}  `json:"arguments"`
 	OutArgs  abi.Arguments   `json:"-"`
 	Sender   *common.Address `json:"from"`
+	// fields below are needed to reconstruct the exact transaction
+	To        *common.Address `json:"to"`
+	Payload   hexutil.Bytes   `json:"input"`
+	Timestamp *big.Int        `json:"timestamp"`
+	// attacker contract through which the transaction is sent (if any) and
+	// call with which attackers re-enter the contract
+	Via           *common.Address `json:"via,omitempty"`
+	ReentryMethod string          `json:"reentryMethod,omitempty"`
+	Reentry       hexutil.Bytes   `json:"reentry,omitempty"`
+}
+
+// location of an opcode in the code of a contract
+type Location struct {
+	Address common.Address `json:"address"`
+	Pc      uint64         `json:"pc"`
+}
+
+type Edge struct {
+	From uint64
+	To   uint64
+}
+
+type Branch struct {
+	Pc    uint64 `json:"pc"`
+	Taken bool   `json:"taken"`
 }
 
 type LastTxResult struct {
@@ -61,6 +90,29 @@ type LastTxResult struct {
 	Receipt          *types.Receipt
 	Overflow         string
 	StructLogger     *vm.StructLogger
+	// opcodes that caused overflow, assertion failure and revert (at the
+	// lowest depth)
+	OverflowAt  *Location
+	AssertionAt *Location
+	RevertAt    *Location
+	// storage written by re-entered call and by the outer call afterwards
+	Reentrancy   string
+	ReentrancyAt *Location
+	// unprivileged accounts that received ether and opcodes that sent it
+	EtherReceived map[common.Address]*Location
+	// contracts whose code sent ether
+	EtherSent map[common.Address]bool
+	// first SELFDESTRUCT that was not reverted
+	SelfdestructAt *Location
+	// privileged-looking state changed by unprivileged sender
+	AccessControl   string
+	AccessControlAt *Location
+}
+
+// clears result of previous transaction, so that oracles don't report it
+// again if the next transaction can't be applied
+func (r *LastTxResult) reset() {
+	*r = LastTxResult{AssertionAtDepth: -1, RevertAtDepth: -1}
 }
 
 type Backend struct {
@@ -70,30 +122,75 @@ type Backend struct {
 	DeployedContracts map[string]*Contract
 	// flattened list of deployed contracts for efficient random generation
 	ContractsList []string
-	// metadata file of truffle project that is being fuzzed
+	// metadata file of project that is being fuzzed
 	Metadata string
 	// input and output of last transaction
 	LastTxIn  *LastTxInput
 	LastTxRes LastTxResult
+	// transactions applied since last snapshot/revert of the state
+	TxSequence []*LastTxInput
 
 	// instruction indices
 	// set of indices for each contract to calculate coverage finally
 	OpcodeIndices map[common.Address]map[uint64]bool
+	// control flow edges (JUMP/JUMPI source -> destination) for each contract
+	EdgeIndices map[common.Address]map[Edge]bool
+	// outcomes of JUMPI instructions for each contract
+	BranchIndices map[common.Address]map[Branch]bool
+	// proxy address -> implementation address, code executed through proxy
+	// is covered in the implementation
+	Proxies map[common.Address]common.Address
+	// number of covered instructions, edges and branches (over all
+	// contracts), used as feedback
+	CoveredOpcodes  int
+	CoveredEdges    int
+	CoveredBranches int
 	// statistics about execution
 	Stats   Stats
 	TxCount int
+	// violations found while fuzzing, each one is saved once
+	Findings []*SavedFinding
+	// output directory of campaign, findings and traces are saved in it
+	OutDir string
+	// source of randomness, shared with the arg pool of the same worker
+	Rand *rand.Rand
+	// block timestamp at the start of campaign
+	StartTime int64
+	// exchange of fuzzing workers, each finding is saved only by the worker
+	// that found it first. Nil if backend isn't used by workers
+	Exchange *Exchange
+	// contracts controlled by fuzzer that call back into their callers
+	Attackers []common.Address
+	// instances of contracts that held ether and that sent ether out,
+	// contracts holding ether that never left are reported as locking it
+	EtherHeld map[common.Address]bool
+	EtherSent map[common.Address]bool
+	// accounts to which senders granted access in transactions since last
+	// snapshot/revert of the state
+	Grants map[common.Address]map[common.Address]bool
+	// contracts created by transactions since last snapshot/revert of the
+	// state
+	Created map[common.Address]bool
+	// header of blocks in which transactions are applied
+	defaultHeader *types.Header
+	// state to which backend is reverted before each sequence
+	snapshot *state.StateDB
 }
 
 func (b *Backend) CommitTransaction(tx *types.Transaction,
 	argPool *argpool.ArgPool, options *Options, header *types.Header) (
 	error, []*types.Log) {
 	b.TxCount = b.TxCount + 1
-	b.LastTxRes.StructLogger = nil
-	b.LastTxRes.Receipt = nil
+	b.LastTxRes.reset()
 	gasPool := *maxGasPool
+	balances := b.contractBalances()
 	err, logs := b.commitTransaction(tx, b.BlockChain, coinBase, &gasPool,
 		b.ChainConfig, b.StateDB, header, argPool, options,
 	)
+	if err == nil {
+		b.recordTransaction(tx, header)
+		b.updateEtherFlows(balances)
+	}
 	return err, logs
 }
 
@@ -137,12 +234,45 @@ func (b *Backend) commitTransaction(
 	return nil, receipt.Logs
 }
 
-// Returns blockchain, statedb and config for fast fuzzing
-// also: - initializes accounts: sets initial balances
-// 			 - reads transactions extracted from truffle project
-//			 - specified in metadata file and applies to backend
-func NewBackend(metadata string, argPool *argpool.ArgPool) *Backend {
-	GetABIMap(metadata)
+// executes message on a copy of the state (state is not modified), returns
+// output and whether execution failed, execution is traced if tracer is set
+func (b *Backend) SimulateCall(from common.Address, to *common.Address, value *big.Int,
+	data []byte, gas uint64, tracer *vm.StructLogger) ([]byte, bool, error) {
+	statedb := b.StateDB.Copy()
+	msg := types.NewMessage(from, to, statedb.GetNonce(from), value, gas,
+		new(big.Int), data, false,
+	)
+	header := types.CopyHeader(GetDefaultHeader(b))
+	context := core.NewEVMContext(msg, header, b.BlockChain, nil)
+	vmConfig := vm.Config{}
+	if tracer != nil {
+		vmConfig = vm.Config{Debug: true, Tracer: tracer}
+	}
+	evm := vm.NewEVM(context, statedb, b.ChainConfig, vmConfig)
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	ret, _, failed, err := core.ApplyMessage(evm, msg, gp)
+	return ret, failed, err
+}
+
+// returns overall coverage, increases whenever new instruction, edge or
+// branch is covered
+func (b *Backend) Coverage() int {
+	return b.CoveredOpcodes + b.CoveredEdges + b.CoveredBranches
+}
+
+// appends input of applied transaction to the sequence of transactions,
+// deployment transactions are not generated by fuzzer and are not recorded
+func (b *Backend) recordTransaction(tx *types.Transaction, header *types.Header) {
+	if b.LastTxIn == nil || tx.To() == nil {
+		return
+	}
+	b.LastTxIn.Timestamp = new(big.Int).Set(header.Time)
+	b.TxSequence = append(b.TxSequence, b.LastTxIn)
+}
+
+// Returns blockchain, statedb and config with initialized accounts (initial
+// balances are set), no contracts are deployed
+func NewEmptyBackend(metadata string) *Backend {
 	db, blockchain, err := core.ExpNewCanonical(ethash.NewFullFaker(), 1, true)
 	if err != nil {
 		panic(fmt.Errorf("error creating blockchain: %v\n", err))
@@ -170,7 +300,7 @@ func NewBackend(metadata string, argPool *argpool.ArgPool) *Backend {
 		statedb.SetBalance(account.Address, account.Amount)
 	}
 
-	backend := &Backend{
+	return &Backend{
 		BlockChain:        blockchain,
 		StateDB:           statedb,
 		ChainConfig:       chainConfig,
@@ -178,7 +308,24 @@ func NewBackend(metadata string, argPool *argpool.ArgPool) *Backend {
 		ContractsList:     make([]string, 0),
 		Metadata:          metadata,
 		OpcodeIndices:     make(map[common.Address]map[uint64]bool),
+		EdgeIndices:       make(map[common.Address]map[Edge]bool),
+		BranchIndices:     make(map[common.Address]map[Branch]bool),
+		Proxies:           make(map[common.Address]common.Address),
+		EtherHeld:         make(map[common.Address]bool),
+		EtherSent:         make(map[common.Address]bool),
+		StartTime:         time.Now().Unix(),
 	}
+}
+
+// Returns blockchain, statedb and config for fast fuzzing
+// also: - initializes accounts: sets initial balances
+// 			 - reads recorded deployment transactions of the project
+//			 - specified in metadata file and applies to backend
+func NewBackend(metadata string, argPool *argpool.ArgPool) *Backend {
+	GetABIMap(metadata)
+	backend := NewEmptyBackend(metadata)
+	backend.Rand = argPool.Rand
+	backend.StartTime = argPool.StartTime
 
 	// read transactions json file and apply transactions to backend
 	for _, tx := range ReadTransactions(getTxJSONFile(metadata)) {
@@ -189,21 +336,28 @@ func NewBackend(metadata string, argPool *argpool.ArgPool) *Backend {
 			ExtractTimestamps:     true,
 		}, GetDefaultHeader(backend))
 	}
+	backend.detectProxies()
 	InitArgPool(argPool, metadata)
 	RemoveLibraries(backend)
+	SeedDictionary(argPool, backend)
+	backend.deployAttackers(argPool)
 
-	for contract, _ := range backend.DeployedContracts {
-		for method := range GetContractABI(contract, metadata).Methods {
+	for _, contract := range GetDeployedContractNames(backend) {
+		for _, method := range GetSortedMethods(contract, metadata) {
 			backend.DeployedContracts[contract].Methods = append(backend.DeployedContracts[contract].Methods, method)
 		}
 		if contract != "Migrations" && len(GetContractABI(contract, backend.Metadata).Methods) > 0 {
 			backend.ContractsList = append(backend.ContractsList, contract)
 		}
+		// instances are passed as arguments to each other
+		for _, address := range backend.DeployedContracts[contract].Addresses {
+			argPool.AddAddress(address)
+		}
 	}
 
 	ProcessConfig(metadata, argPool, backend)
 	if len(backend.ContractsList) < 1 {
-		log.Error(fmt.Sprintf("Truffle deployment script doesn't deploy any contract"))
+		log.Error(fmt.Sprintf("Deployment script doesn't deploy any contract"))
 		os.Exit(0)
 	}
 	return backend
@@ -0,0 +1,210 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// solc appends CBOR encoded metadata to the runtime code followed by
+// 2 bytes (big endian) length of the encoded metadata, e.g.
+// a1 65 "bzzr0" 58 20 <32 bytes> 00 29
+// keys holding the hash of the metadata file
+var metadataHashKeys = []string{"ipfs", "bzzr1", "bzzr0"}
+
+var errCBOR = errors.New("malformed CBOR metadata")
+
+// minimal CBOR decoder, supports only the subset used by solc
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) readHeader() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBOR
+	}
+	major := d.data[d.pos] >> 5
+	info := d.data[d.pos] & 0x1f
+	d.pos++
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, errCBOR
+	}
+	if d.pos+size > len(d.data) {
+		return 0, 0, errCBOR
+	}
+	var arg uint64
+	for _, b := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, arg, nil
+}
+
+// reads byte string, text string or simple value (bool)
+func (d *cborDecoder) readValue() ([]byte, error) {
+	major, arg, err := d.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 2, 3:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+		value := d.data[d.pos : d.pos+int(arg)]
+		d.pos += int(arg)
+		return value, nil
+	case 7:
+		// false, true
+		if arg == 20 || arg == 21 {
+			return []byte{byte(arg - 20)}, nil
+		}
+	}
+	return nil, errCBOR
+}
+
+func decodeCBORMap(data []byte) (map[string][]byte, error) {
+	d := &cborDecoder{data: data}
+	major, size, err := d.readHeader()
+	if err != nil || major != 5 {
+		return nil, errCBOR
+	}
+	res := make(map[string][]byte)
+	for i := uint64(0); i < size; i++ {
+		key, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		res[string(key)] = value
+	}
+	if d.pos != len(data) {
+		return nil, errCBOR
+	}
+	return res, nil
+}
+
+// splits runtime code into code and decoded metadata
+func splitCodeMetadata(code []byte) ([]byte, map[string][]byte, bool) {
+	if len(code) < 2 {
+		return code, nil, false
+	}
+	size := int(binary.BigEndian.Uint16(code[len(code)-2:]))
+	if size == 0 || size+2 > len(code) {
+		return code, nil, false
+	}
+	start := len(code) - 2 - size
+	metadata, err := decodeCBORMap(code[start : len(code)-2])
+	if err != nil {
+		return code, nil, false
+	}
+	return code[:start], metadata, true
+}
+
+// returns runtime code without appended metadata
+func stripCodeMetadata(code []byte) []byte {
+	stripped, _, _ := splitCodeMetadata(code)
+	return stripped
+}
+
+// extracts metadata hash (swarm or ipfs) from runtime code,
+// hash is prefixed with its kind e.g. "bzzr1:<hex>"
+func GetMetadataHash(code []byte) (string, bool) {
+	_, metadata, found := splitCodeMetadata(code)
+	if !found {
+		return "", false
+	}
+	for _, key := range metadataHashKeys {
+		if hash, found := metadata[key]; found {
+			return fmt.Sprintf("%v:%x", key, hash), true
+		}
+	}
+	return "", false
+}
+
+// decodes deployedBytecode from artifact, library placeholders are zeroed
+func decodeBytecode(bytecode string) []byte {
+	code, err := hex.DecodeString(ReplacePlaceHolders(bytecode))
+	if err != nil {
+		return nil
+	}
+	return code
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compares runtime code of deployed contract with deployedBytecode
+// from artifact ignoring metadata, addresses of linked libraries and
+// immutables, which are zero (PUSH20 and PUSH32) in the artifact
+func matchesDeployedCode(artifact []byte, code []byte) bool {
+	artifact = stripCodeMetadata(artifact)
+	code = stripCodeMetadata(code)
+	if len(artifact) == 0 || len(artifact) != len(code) {
+		return false
+	}
+	wildcard := make([]bool, len(artifact))
+	it := asm.NewInstructionIterator(artifact)
+	for it.Next() {
+		op := it.Op()
+		if (op == vm.PUSH20 || op == vm.PUSH32) && isZero(it.Arg()) {
+			for i := range it.Arg() {
+				wildcard[int(it.PC())+1+i] = true
+			}
+		}
+	}
+	for i := range artifact {
+		if !wildcard[i] && artifact[i] != code[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func cborHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(strings.Replace(s, " ", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+var (
+	hash32 = strings.Repeat("11", 32)
+	hash34 = "1220" + strings.Repeat("22", 32)
+	// solc: 0.5.12
+	solcVersion = "64 736f6c63 43 00050c"
+)
+
+func TestDecodeCBORMapHashKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoded  string
+		key      string
+		value    string
+		keyCount int
+	}{
+		// a1 65 "bzzr0" 58 20 <32 bytes>
+		{"bzzr0", "a1 65 627a7a7230 5820" + hash32, "bzzr0", hash32, 1},
+		{"bzzr1", "a2 65 627a7a7231 5820" + hash32 + solcVersion, "bzzr1", hash32, 2},
+		{"ipfs", "a2 64 69706673 5822" + hash34 + solcVersion, "ipfs", hash34, 2},
+		// "experimental": true
+		{"experimental", "a3 64 69706673 5822" + hash34 + "6c 6578706572696d656e74616c f5" + solcVersion, "ipfs", hash34, 3},
+	}
+	for _, test := range tests {
+		metadata, err := decodeCBORMap(cborHex(t, test.encoded))
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", test.name, err)
+			continue
+		}
+		if len(metadata) != test.keyCount {
+			t.Errorf("%v: got %v keys, want %v", test.name, len(metadata), test.keyCount)
+		}
+		if got := hex.EncodeToString(metadata[test.key]); got != test.value {
+			t.Errorf("%v: got %v = %v, want %v", test.name, test.key, got, test.value)
+		}
+	}
+}
+
+func TestDecodeCBORMapTruncated(t *testing.T) {
+	encoded := cborHex(t, "a2 64 69706673 5822"+hash34+solcVersion)
+	// every proper prefix misses a header, a key or a part of a value
+	for size := 0; size < len(encoded); size++ {
+		if _, err := decodeCBORMap(encoded[:size]); err == nil {
+			t.Errorf("no error for encoding truncated to %v of %v bytes", size, len(encoded))
+		}
+	}
+}
+
+func TestDecodeCBORMapMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"trailing byte", "a1 65 627a7a7230 5820" + hash32 + "00"},
+		{"not a map", "82 01 02"},
+		{"integer value", "a1 65 627a7a7230 01"},
+		{"reserved additional info", "a1 65 627a7a7230 5c"},
+		{"length exceeds data", "a1 65 627a7a7230 5b ffffffffffffffff"},
+	}
+	for _, test := range tests {
+		if _, err := decodeCBORMap(cborHex(t, test.encoded)); err == nil {
+			t.Errorf("%v: no error", test.name)
+		}
+	}
+}
+
+func TestGetMetadataHash(t *testing.T) {
+	code := cborHex(t, "6080604052 00")
+	metadata := cborHex(t, "a2 64 69706673 5822"+hash34+solcVersion)
+	full := append(append(append([]byte{}, code...), metadata...), 0, byte(len(metadata)))
+
+	hash, found := GetMetadataHash(full)
+	if !found || hash != "ipfs:"+hash34 {
+		t.Errorf("got %v %v, want ipfs:%v", hash, found, hash34)
+	}
+	if stripped := stripCodeMetadata(full); !bytes.Equal(stripped, code) {
+		t.Errorf("stripped code %x, want %x", stripped, code)
+	}
+	// length pointing before the start of the code
+	if _, found := GetMetadataHash(append(code, 0, 0xff)); found {
+		t.Errorf("hash found in code without metadata")
+	}
+}
@@ -0,0 +1,264 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// checkpoints of workers are saved in this subdirectory of the campaign
+const checkpointDir = "checkpoint"
+
+// state of worker that is needed to resume the campaign on top of freshly
+// deployed contracts
+type Checkpoint struct {
+	TxCount  int               `json:"txCount"`
+	ArgPool  *argpool.Contents `json:"argPool"`
+	Stats    StatsMap          `json:"stats"`
+	Result   ResultsMap        `json:"result"`
+	Findings []*SavedFinding   `json:"findings"`
+	Corpus   [][]*LastTxInput  `json:"corpus"`
+	// covered instructions, edges and JUMPI outcomes of each contract
+	OpcodeIndices map[common.Address][]uint64 `json:"opcodeIndices"`
+	EdgeIndices   map[common.Address][]Edge   `json:"edgeIndices"`
+	BranchIndices map[common.Address][]Branch `json:"branchIndices"`
+	// instances of contracts that held ether and that sent ether out
+	EtherHeld []common.Address `json:"etherHeld"`
+	EtherSent []common.Address `json:"etherSent"`
+	// instances of contracts including those created during fuzzing, and
+	// implementations of proxies
+	Instances map[string][]common.Address       `json:"instances"`
+	Proxies   map[common.Address]common.Address `json:"proxies"`
+}
+
+func getCheckpointFilename(dir string, id int) string {
+	return filepath.Join(dir, checkpointDir, fmt.Sprintf("worker_%v.json", id))
+}
+
+// returns paths of checkpoints saved in campaign directory, ordered by worker
+func GetCheckpointFiles(dir string) []string {
+	var res []string
+	for id := 0; ; id++ {
+		filename := getCheckpointFilename(dir, id)
+		if _, err := os.Stat(filename); err != nil {
+			return res
+		}
+		res = append(res, filename)
+	}
+}
+
+func (w *Worker) newCheckpoint() *Checkpoint {
+	b := w.Backend
+	checkpoint := &Checkpoint{
+		TxCount:       b.TxCount,
+		ArgPool:       w.ArgPool.Contents(),
+		Stats:         b.Stats.statsMap,
+		Result:        w.Result,
+		Findings:      b.Findings,
+		Corpus:        w.Corpus.Entries,
+		OpcodeIndices: make(map[common.Address][]uint64),
+		EdgeIndices:   make(map[common.Address][]Edge),
+		BranchIndices: make(map[common.Address][]Branch),
+		Instances:     make(map[string][]common.Address),
+		Proxies:       b.Proxies,
+	}
+	for name, contract := range b.DeployedContracts {
+		checkpoint.Instances[name] = contract.Addresses
+	}
+	for address, pcs := range b.OpcodeIndices {
+		for pc := range pcs {
+			checkpoint.OpcodeIndices[address] = append(checkpoint.OpcodeIndices[address], pc)
+		}
+		sort.Slice(checkpoint.OpcodeIndices[address], func(i, j int) bool {
+			return checkpoint.OpcodeIndices[address][i] < checkpoint.OpcodeIndices[address][j]
+		})
+	}
+	for address, edges := range b.EdgeIndices {
+		for edge := range edges {
+			checkpoint.EdgeIndices[address] = append(checkpoint.EdgeIndices[address], edge)
+		}
+	}
+	for address, branches := range b.BranchIndices {
+		for branch := range branches {
+			checkpoint.BranchIndices[address] = append(checkpoint.BranchIndices[address], branch)
+		}
+	}
+	for address := range b.EtherHeld {
+		checkpoint.EtherHeld = append(checkpoint.EtherHeld, address)
+	}
+	for address := range b.EtherSent {
+		checkpoint.EtherSent = append(checkpoint.EtherSent, address)
+	}
+	return checkpoint
+}
+
+// saves checkpoint of worker to the output directory of its backend, file
+// is replaced only after the new checkpoint was completely written
+func (w *Worker) SaveCheckpoint() {
+	filename := getCheckpointFilename(w.Backend.OutDir, w.ID)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		log.Error(fmt.Sprintf("Error creating checkpoint directory: %v", err))
+		return
+	}
+	jsonOut, err := json.Marshal(w.newCheckpoint())
+	if err != nil {
+		log.Error(fmt.Sprintf("Error marshalling checkpoint: %v", err))
+		return
+	}
+	if err := ioutil.WriteFile(filename+".tmp", jsonOut, 0644); err != nil {
+		log.Error(fmt.Sprintf("Error writing checkpoint: %v", err))
+		return
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		log.Error(fmt.Sprintf("Error writing checkpoint: %v", err))
+		return
+	}
+	log.Debug(fmt.Sprintf("Saved checkpoint of worker %v to %v", w.ID, filename))
+}
+
+// adds state saved in checkpoint to the worker: contracts created during
+// fuzzing, coverage, ether flows, arg pool values, statistics, results,
+// findings and corpus entries
+func (w *Worker) RestoreCheckpoint(filename string) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		panic(fmt.Errorf("Error reading checkpoint: %v\n", filename))
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(content, checkpoint); err != nil {
+		panic(fmt.Errorf("Error unmarshalling checkpoint %v: %+v\n", filename, err))
+	}
+	b := w.Backend
+	b.TxCount = b.TxCount + checkpoint.TxCount
+	// contracts created during fuzzing are fuzz targets of resumed campaign,
+	// names are sorted so that order of targets doesn't depend on map order
+	for proxy, implementation := range checkpoint.Proxies {
+		b.Proxies[proxy] = implementation
+	}
+	names := make([]string, 0, len(checkpoint.Instances))
+	for name := range checkpoint.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, address := range checkpoint.Instances[name] {
+			b.addInstance(name, address, true)
+		}
+	}
+	w.ArgPool.Restore(checkpoint.ArgPool)
+	b.Stats.Merge(&Stats{statsMap: checkpoint.Stats})
+	w.Result.merge(checkpoint.Result)
+	for _, finding := range checkpoint.Findings {
+		if err := decodeTransactions(finding.Transactions, b.Metadata); err != nil {
+			panic(fmt.Errorf("Error restoring finding of checkpoint %v: %v\n", filename, err))
+		}
+		b.mergeFinding(finding)
+	}
+	for _, entry := range checkpoint.Corpus {
+		if err := decodeTransactions(entry, b.Metadata); err != nil {
+			panic(fmt.Errorf("Error restoring corpus of checkpoint %v: %v\n", filename, err))
+		}
+		w.Corpus.Add(entry)
+	}
+	// restored entries were already exchanged before the checkpoint
+	w.published = w.Corpus.Size()
+	b.restoreCoverage(checkpoint)
+	for _, address := range checkpoint.EtherHeld {
+		b.EtherHeld[address] = true
+	}
+	for _, address := range checkpoint.EtherSent {
+		b.EtherSent[address] = true
+	}
+	log.Info(fmt.Sprintf("Worker %v restored checkpoint %v: %v transactions, %v findings, %v corpus entries",
+		w.ID, filename, checkpoint.TxCount, len(checkpoint.Findings), len(checkpoint.Corpus),
+	))
+}
+
+// adds coverage saved in checkpoint, counters of covered instructions, edges
+// and branches are increased accordingly
+func (b *Backend) restoreCoverage(checkpoint *Checkpoint) {
+	for address, pcs := range checkpoint.OpcodeIndices {
+		if b.OpcodeIndices[address] == nil {
+			b.OpcodeIndices[address] = make(map[uint64]bool)
+		}
+		for _, pc := range pcs {
+			if !b.OpcodeIndices[address][pc] {
+				b.OpcodeIndices[address][pc] = true
+				b.CoveredOpcodes++
+			}
+		}
+	}
+	for address, edges := range checkpoint.EdgeIndices {
+		if b.EdgeIndices[address] == nil {
+			b.EdgeIndices[address] = make(map[Edge]bool)
+		}
+		for _, edge := range edges {
+			if !b.EdgeIndices[address][edge] {
+				b.EdgeIndices[address][edge] = true
+				b.CoveredEdges++
+			}
+		}
+	}
+	for address, branches := range checkpoint.BranchIndices {
+		if b.BranchIndices[address] == nil {
+			b.BranchIndices[address] = make(map[Branch]bool)
+		}
+		for _, branch := range branches {
+			if !b.BranchIndices[address][branch] {
+				b.BranchIndices[address][branch] = true
+				b.CoveredBranches++
+			}
+		}
+	}
+}
+
+// Restores checkpoints saved in campaign directory, checkpoint of worker i is
+// restored by worker i % len(workers). If the campaign is resumed with fewer
+// workers, checkpoints of the remaining workers are removed once their state
+// was saved by the workers that restored them. Returns number of restored
+// checkpoints.
+func ResumeWorkers(dir string, workers []*Worker) int {
+	files := GetCheckpointFiles(dir)
+	for i, filename := range files {
+		workers[i%len(workers)].RestoreCheckpoint(filename)
+	}
+	for _, w := range workers {
+		w.SaveCheckpoint()
+	}
+	for i := len(workers); i < len(files); i++ {
+		if err := os.Remove(files[i]); err != nil {
+			log.Warn(fmt.Sprintf("Error removing checkpoint %v: %v", files[i], err))
+		}
+	}
+	return len(files)
+}
@@ -0,0 +1,185 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// writes truffle project with Child contract and returns its metadata file
+func writeCheckpointProject(t *testing.T, dir string) string {
+	accounts, err := filepath.Abs("../config/accounts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"build/contracts/Child.json": `{"contractName":"Child","deployedBytecode":"0x00","abi":[
+			{"type":"function","name":"set","inputs":[{"name":"x","type":"uint256"}],"outputs":[]}]}`,
+		"config.json": `{}`,
+		"metadata.json": fmt.Sprintf(`{"projectDir":%q,"accounts":%q,"config":%q}`,
+			dir, accounts, filepath.Join(dir, "config.json"),
+		),
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, "metadata.json")
+}
+
+func newCheckpointWorker(id int, metadata string, outDir string) *Worker {
+	backend := NewEmptyBackend(metadata)
+	backend.OutDir = outDir
+	return &Worker{
+		ID:      id,
+		Backend: backend,
+		ArgPool: argpool.NewArgPool(rand.New(rand.NewSource(int64(id))), 0),
+		Corpus:  NewCorpus(),
+		Result:  make(ResultsMap),
+	}
+}
+
+// state found by worker id of the saved campaign
+func fillCheckpointWorker(w *Worker) {
+	b := w.Backend
+	child := common.BigToAddress(big.NewInt(int64(100 + w.ID)))
+	proxy := common.BigToAddress(big.NewInt(int64(200 + w.ID)))
+	b.addInstance("Child", child, true)
+	b.Proxies[proxy] = child
+	b.OpcodeIndices[child] = map[uint64]bool{uint64(w.ID): true}
+	b.EtherHeld[child] = true
+	b.TxCount = 10
+	w.ArgPool.AddInt64(int64(1000 + w.ID))
+	args := []interface{}{big.NewInt(int64(w.ID))}
+	w.Corpus.Add([]*LastTxInput{{
+		Contract: "Child",
+		Method:   "set",
+		Ether:    big.NewInt(0),
+		Input:    &args,
+		To:       &child,
+		Payload:  GetCallBytecode("Child", "set", args, b.Metadata),
+	}})
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		saved   int
+		resumed int
+	}{
+		{"same workers", 2, 2},
+		{"fewer workers", 3, 2},
+		{"single worker", 3, 1},
+	}
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ResetContractData()
+	fuzzingConfig = nil
+	defer func() {
+		ResetContractData()
+		fuzzingConfig = nil
+	}()
+	metadata := writeCheckpointProject(t, dir)
+
+	for i, test := range tests {
+		outDir := filepath.Join(dir, fmt.Sprintf("campaign_%v", i))
+		for id := 0; id < test.saved; id++ {
+			w := newCheckpointWorker(id, metadata, outDir)
+			fillCheckpointWorker(w)
+			w.SaveCheckpoint()
+		}
+		// resumed twice, the second time from checkpoints merged by the first
+		var workers []*Worker
+		for round := 0; round < 2; round++ {
+			workers = make([]*Worker, test.resumed)
+			for id := range workers {
+				workers[id] = newCheckpointWorker(id, metadata, outDir)
+			}
+			want := test.saved
+			if round > 0 {
+				want = test.resumed
+			}
+			if restored := ResumeWorkers(outDir, workers); restored != want {
+				t.Errorf("%v: round %v restored %v checkpoints, want %v", test.name, round, restored, want)
+			}
+			if files := GetCheckpointFiles(outDir); len(files) != test.resumed {
+				t.Errorf("%v: round %v left %v checkpoints, want %v", test.name, round, len(files), test.resumed)
+			}
+		}
+
+		txCount := 0
+		for _, w := range workers {
+			txCount += w.Backend.TxCount
+			if len(w.Backend.ContractsList) != 1 || w.Backend.ContractsList[0] != "Child" {
+				t.Errorf("%v: worker %v fuzzes %v, want [Child]", test.name, w.ID, w.Backend.ContractsList)
+			}
+		}
+		if txCount != 10*test.saved {
+			t.Errorf("%v: restored %v transactions, want %v", test.name, txCount, 10*test.saved)
+		}
+		for id := 0; id < test.saved; id++ {
+			w := workers[id%test.resumed]
+			b := w.Backend
+			child := common.BigToAddress(big.NewInt(int64(100 + id)))
+			proxy := common.BigToAddress(big.NewInt(int64(200 + id)))
+			if !b.hasInstance("Child", child) {
+				t.Errorf("%v: worker %v misses instance %x", test.name, w.ID, child)
+			}
+			if b.Proxies[proxy] != child {
+				t.Errorf("%v: worker %v has proxy %x of %x, want %x", test.name, w.ID, proxy, b.Proxies[proxy], child)
+			}
+			if !b.OpcodeIndices[child][uint64(id)] || !b.EtherHeld[child] {
+				t.Errorf("%v: worker %v misses coverage or ether flow of %x", test.name, w.ID, child)
+			}
+			if !w.ArgPool.Int64Pool.Contains(int64(1000 + id)) {
+				t.Errorf("%v: worker %v misses value %v", test.name, w.ID, 1000+id)
+			}
+			found := 0
+			for _, txs := range w.Corpus.Entries {
+				if *txs[0].To == child && (*txs[0].Input)[0].(*big.Int).Int64() == int64(id) {
+					found++
+				}
+			}
+			if found != 1 {
+				t.Errorf("%v: worker %v has %v corpus entries of worker %v, want 1", test.name, w.ID, found, id)
+			}
+		}
+	}
+}
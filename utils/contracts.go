@@ -23,18 +23,17 @@ package utils
 
 import (
 	"bytes"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"strings"
+	"math/big"
+	"sort"
 
 	"fuzzer/argpool"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/log"
 )
 
@@ -46,8 +45,9 @@ var (
 	// so we keep that info in payable map: payable[contract][method]
 	Payable   map[string]map[string]bool
 	Libraries map[string]bool
-	// swarm hash prefix in bytecode
-	bzzr0 = fmt.Sprintf("%x", append([]byte{0xa1, 0x65}, []byte("bzzr0")...))
+	// numeric and string literals from AST of each contract
+	NumericLiterals map[string][]*big.Int
+	StringLiterals  map[string][]string
 )
 
 type Contract struct {
@@ -63,45 +63,28 @@ func ResetContractData() {
 	ABIMap = nil
 	Payable = nil
 	Libraries = nil
+	NumericLiterals = nil
+	StringLiterals = nil
+	loadedArtifacts = nil
+	sourceFiles = nil
 }
 
 func IsPayable(contract, method string) bool {
 	return Payable[contract][method]
 }
 
-// extracts swarm hash from deployment bytecode
-func GetSwarmHash(bytecode string) (string, bool) {
-	idx := strings.LastIndex(bytecode, bzzr0)
-	if idx == -1 {
-		return "", false
-	}
-	swarmHash := bytecode[idx+len(bzzr0) : idx+len(bzzr0)+64]
-	return swarmHash, true
-}
-
 func ReadDeployedBytecodes(metadata string) map[string]string {
 	if DeployedBytecodes != nil {
 		return DeployedBytecodes
 	}
-	DeployedBytecodes := make(map[string]string)
-
-	truffleDir := getTruffleDir(metadata)
-	files, err := ioutil.ReadDir(fmt.Sprintf("%v/build/contracts/", truffleDir))
-	if err != nil {
-		panic(fmt.Errorf("truffle project folder doesn't exist: %+v\n", err))
-	}
-	for _, f := range files {
-		contractFile := fmt.Sprintf("%v/build/contracts/%v", truffleDir, f.Name())
-		contractJSON, _ := ioutil.ReadFile(contractFile)
-		var M map[string]string
-		json.Unmarshal(contractJSON, &M)
-		// trim 0x prefix from deployed code
-		DeployedBytecodes[getFilename(f.Name())] = M["deployedBytecode"][2:]
+	DeployedBytecodes = make(map[string]string)
+	for _, artifact := range GetArtifacts(metadata) {
+		DeployedBytecodes[artifact.Name] = artifact.DeployedBytecode
 	}
 	return DeployedBytecodes
 }
 
-// returns map of ContractName -> Swarm hash (of deployment code)
+// returns map of metadata hash (of deployed code) -> ContractName
 func ReadContractsHashes(metadata string) map[string]string {
 	if ContractHashes == nil {
 		ContractHashes = make(map[string]map[string]string)
@@ -113,9 +96,9 @@ func ReadContractsHashes(metadata string) map[string]string {
 
 	deployedCodes := ReadDeployedBytecodes(metadata)
 	for filename, code := range deployedCodes {
-		hash, found := GetSwarmHash(code)
+		hash, found := GetMetadataHash(decodeBytecode(code))
 		if !found {
-			log.Trace(fmt.Sprintf("swarm hash was not found in contract ABI: %+v",
+			log.Trace(fmt.Sprintf("metadata hash was not found in deployed bytecode: %+v",
 				filename,
 			))
 			continue
@@ -125,11 +108,26 @@ func ReadContractsHashes(metadata string) map[string]string {
 	return ContractHashes[metadata]
 }
 
-func GetContractNameByHash(hash string, metadata string) string {
-	if contractName, found := ReadContractsHashes(metadata)[hash]; found {
-		return contractName
+// identifies contract by metadata hash of its runtime code, if hash is
+// missing or unknown runtime code is compared with deployed bytecodes
+func GetContractNameByCode(code []byte, metadata string) (string, bool) {
+	if hash, found := GetMetadataHash(code); found {
+		if contractName, found := ReadContractsHashes(metadata)[hash]; found {
+			return contractName, true
+		}
 	}
-	panic(fmt.Sprintf("Contract with hash: %v was not found\n", hash))
+	deployedCodes := ReadDeployedBytecodes(metadata)
+	names := make([]string, 0, len(deployedCodes))
+	for name := range deployedCodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if matchesDeployedCode(decodeBytecode(deployedCodes[name]), code) {
+			return name, true
+		}
+	}
+	return "", false
 }
 
 // returns map of ContractName -> ABI (functs, events ...	)
@@ -137,75 +135,25 @@ func GetABIMap(metadata string) map[string]abi.ABI {
 	if ABIMap != nil {
 		return ABIMap
 	}
-	type JSONNode struct {
-		ContractKind string `json:"contractKind,omitempty"`
-		Name         string `json:"name,omitempty"`
-	}
-	type JSONNodes struct {
-		Nodes []JSONNode `json:"nodes"`
-	}
-	type JSONAST struct {
-		ContractName string    `json:"contractName"`
-		AST          JSONNodes `json:"ast"`
-	}
-	type JSONABI struct {
-		ABI abi.ABI `json:"abi"`
-	}
-
-	truffleDir := getTruffleDir(metadata)
 	ABIMap = make(map[string]abi.ABI)
 	Payable = make(map[string]map[string]bool)
 	Libraries = make(map[string]bool)
+	NumericLiterals = make(map[string][]*big.Int)
+	StringLiterals = make(map[string][]string)
 
-	files, err := ioutil.ReadDir(fmt.Sprintf("%v/build/contracts/", truffleDir))
-	if err != nil {
-		panic(fmt.Errorf("truffle project folder doesn't exist: %+v\n", err))
-	}
-	for _, f := range files {
-		abiFile := fmt.Sprintf("%v/build/contracts/%v", truffleDir, f.Name())
-		abiBytes, err := ioutil.ReadFile(abiFile)
-		if err != nil {
-			panic(fmt.Errorf("Error reading abi file: %+v\n", err))
-		}
-		dec := json.NewDecoder(strings.NewReader(string(abiBytes)))
-		var ast JSONAST
-		if err := dec.Decode(&ast); err != nil {
-			panic(fmt.Errorf("Error processing contract ast: %+v\n", err))
-		}
-		for _, node := range ast.AST.Nodes {
-			if node.ContractKind == "library" {
-				Libraries[node.Name] = true
-			}
-		}
+	for _, artifact := range GetArtifacts(metadata) {
+		collectASTLiterals(artifact.Name, artifact.AST)
 		// if contract is library ignore it's abi
-		if _, ok := Libraries[ast.ContractName]; ok {
+		if artifact.Library {
+			Libraries[artifact.Name] = true
 			continue
 		}
-		dec = json.NewDecoder(strings.NewReader(string(abiBytes)))
-
-		var jsonABI JSONABI
-		if err := dec.Decode(&jsonABI); err != nil {
+		var evmABI abi.ABI
+		if err := json.Unmarshal(artifact.ABI, &evmABI); err != nil {
 			panic(fmt.Errorf("Error processing contract ABI: %+v\n", err))
 		}
-		evmABI := jsonABI.ABI
-		filename := getFilename(f.Name())
-		ABIMap[filename] = evmABI
-		// Extract payable information about methods
-		type method struct {
-			Name    string `json:"name"`
-			Payable bool   `json:"payable"`
-		}
-		type methods struct {
-			Methods []method `json:"abi"`
-		}
-		dat := methods{}
-		if err := json.Unmarshal(abiBytes, &dat); err != nil {
-			panic(err)
-		}
-		Payable[filename] = make(map[string]bool)
-		for _, method := range dat.Methods {
-			Payable[filename][method.Name] = method.Payable
-		}
+		ABIMap[artifact.Name] = evmABI
+		Payable[artifact.Name] = artifact.Payable
 	}
 	return ABIMap
 }
@@ -230,14 +178,59 @@ func GetContractMethod(contract string, method string, metadata string) abi.Meth
 	panic(fmt.Sprintf("Contract: %v, method: %v not found", contract, method))
 }
 
+// returns names of deployed contracts in alphabetical order, so that
+// campaigns with the same seed are reproducible despite random map order
+func GetDeployedContractNames(backend *Backend) []string {
+	names := make([]string, 0, len(backend.DeployedContracts))
+	for name := range backend.DeployedContracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns methods of contract in alphabetical order
+func GetSortedMethods(contract string, metadata string) []string {
+	var methods []string
+	for method := range GetContractABI(contract, metadata).Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
 func GetRandomContract(backend *Backend) string {
 	size := len(backend.ContractsList)
-	return backend.ContractsList[rand.Int()%size]
+	return backend.ContractsList[backend.Rand.Int()%size]
+}
+
+// checks if backend knows some instance of contract, contracts created by
+// sequences of other workers or of resumed campaign may be unknown
+func hasInstances(contract string, backend *Backend) bool {
+	deployed := backend.DeployedContracts[contract]
+	return deployed != nil && len(deployed.Addresses) > 0
+}
+
+// returns address of random deployed instance of contract, instances
+// created during fuzzing don't exist after the state was reverted
+// so instances with code are preferred
+func GetRandomInstance(contract string, backend *Backend) common.Address {
+	addresses := backend.DeployedContracts[contract].Addresses
+	var alive []common.Address
+	for _, address := range addresses {
+		if backend.StateDB.GetCodeSize(address) > 0 {
+			alive = append(alive, address)
+		}
+	}
+	if len(alive) == 0 {
+		return addresses[backend.Rand.Int()%len(addresses)]
+	}
+	return alive[backend.Rand.Int()%len(alive)]
 }
 
 func GetRandomMethod(contract string, backend *Backend) string {
 	methods := backend.DeployedContracts[contract].Methods
-	return methods[rand.Int()%len(methods)]
+	return methods[backend.Rand.Int()%len(methods)]
 }
 
 // returns bytecode for contract method call with specified arguments
@@ -254,21 +247,33 @@ func GetCallBytecode(contract string, method string, args []interface{},
 	return input
 }
 
-func GetOpcodeIndices(metadata string, contract string) []uint64 {
+// returns deployed bytecode of contract without metadata
+func getRuntimeCode(metadata string, contract string) []byte {
 	bytecodes := ReadDeployedBytecodes(metadata)
-	bytecode := bytecodes[contract]
-	idx := strings.LastIndex(bytecode, bzzr0)
-	// last opcode is STOP
-	bytecode = bytecode[:idx-1]
+	return stripCodeMetadata(decodeBytecode(bytecodes[contract]))
+}
+
+func GetOpcodeIndices(metadata string, contract string) []uint64 {
 	var res []uint64
-	script, _ := hex.DecodeString(ReplacePlaceHolders(bytecode))
-	it := asm.NewInstructionIterator(script)
+	it := asm.NewInstructionIterator(getRuntimeCode(metadata, contract))
 	for it.Next() {
 		res = append(res, it.PC())
 	}
 	return res
 }
 
+// returns indices of JUMPI instructions, each of them has two branches
+func GetBranchIndices(metadata string, contract string) []uint64 {
+	var res []uint64
+	it := asm.NewInstructionIterator(getRuntimeCode(metadata, contract))
+	for it.Next() {
+		if it.Op() == vm.JUMPI {
+			res = append(res, it.PC())
+		}
+	}
+	return res
+}
+
 func deleteFromSlice(val string, a []string) []string {
 	for i, s := range a {
 		if s == val {
@@ -311,7 +316,13 @@ func ReplacePlaceHolders(bytecode string) string {
 // to configuration, adds timestamps from config to pool
 func ProcessConfig(metadata string, argPool *argpool.ArgPool, backend *Backend) {
 	fuzzingConfig := GetConfig(metadata)
-	for contract, config := range fuzzingConfig {
+	contracts := make([]string, 0, len(fuzzingConfig))
+	for contract := range fuzzingConfig {
+		contracts = append(contracts, contract)
+	}
+	sort.Strings(contracts)
+	for _, contract := range contracts {
+		config := fuzzingConfig[contract]
 		log.Debug(fmt.Sprintf("Ignoring Config for Contract: %v - %v", contract, config))
 		for _, timestamp := range config.Timestamps {
 			argPool.AddTimestamp(timestamp)
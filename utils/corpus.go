@@ -0,0 +1,250 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// maximal number of transactions in a sequence
+	maxSequenceLength = 32
+	// maximal number of transactions of a freshly generated sequence
+	maxFreshSequenceLength = 8
+	// maximal number of mutations applied to a corpus entry at once
+	maxMutations = 4
+)
+
+// Sequences of transactions that increased coverage when they were executed
+// starting from the snapshot state. New sequences are derived by mutating them.
+type Corpus struct {
+	Entries [][]*LastTxInput
+}
+
+func NewCorpus() *Corpus {
+	return &Corpus{
+		Entries: make([][]*LastTxInput, 0),
+	}
+}
+
+func (c *Corpus) Add(txs []*LastTxInput) {
+	entry := make([]*LastTxInput, len(txs))
+	copy(entry, txs)
+	c.Entries = append(c.Entries, entry)
+	log.Debug(fmt.Sprintf("new corpus entry of %v transactions, corpus size: %v", len(entry), len(c.Entries)))
+}
+
+func (c *Corpus) Size() int {
+	return len(c.Entries)
+}
+
+// returns copy of argument that doesn't share *big.Int values (also those
+// nested in arrays and slices) with the original
+func copyArg(arg interface{}) interface{} {
+	switch value := arg.(type) {
+	case *big.Int:
+		if value != nil {
+			return new(big.Int).Set(value)
+		}
+		return arg
+	case []byte:
+		return append([]byte(nil), value...)
+	}
+	v := reflect.ValueOf(arg)
+	var cpy reflect.Value
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return arg
+		}
+		cpy = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	case reflect.Array:
+		cpy = reflect.New(v.Type()).Elem()
+	default:
+		return arg
+	}
+	for i := 0; i < v.Len(); i++ {
+		if elem := reflect.ValueOf(copyArg(v.Index(i).Interface())); elem.IsValid() {
+			cpy.Index(i).Set(elem)
+		}
+	}
+	return cpy.Interface()
+}
+
+func copyArgs(args []interface{}) []interface{} {
+	cpy := make([]interface{}, len(args))
+	for i, arg := range args {
+		cpy[i] = copyArg(arg)
+	}
+	return cpy
+}
+
+// converts recorded transaction to hint, so that Rec generates the same
+// transaction again. Mutations of the hint don't change the recorded input.
+func hintFromInput(input *LastTxInput) *Hint {
+	var args []interface{}
+	if input.Input != nil {
+		args = copyArgs(*input.Input)
+	}
+	var timestamp *big.Int
+	if input.Timestamp != nil {
+		timestamp = new(big.Int).Set(input.Timestamp)
+	}
+	return &Hint{
+		Contract:  input.Contract,
+		Method:    input.Method,
+		Args:      args,
+		Amount:    new(big.Int).Set(input.Ether),
+		Sender:    input.Sender,
+		Fallback:  input.Method == "",
+		Timestamp: timestamp,
+		Address:   input.To,
+
+		Via:           input.Via,
+		ReentryMethod: input.ReentryMethod,
+		Reentry:       input.Reentry,
+	}
+}
+
+// empty hint, contract, method, arguments... are generated by Rec.
+// Hints are not restricted to specific contract, values extracted from other
+// contracts are important for fuzzing main contract
+func randomHint(argPool *argpool.ArgPool) *Hint {
+	return &Hint{Timestamp: argPool.CurrentTimestamp()}
+}
+
+// applies single random mutation to the sequence
+func mutateSequence(backend *Backend, argPool *argpool.ArgPool, hints []*Hint) []*Hint {
+	switch backend.Rand.Intn(8) {
+	case 0:
+		// insert random transaction
+		if len(hints) < maxSequenceLength {
+			idx := backend.Rand.Intn(len(hints) + 1)
+			hints = append(hints, nil)
+			copy(hints[idx+1:], hints[idx:])
+			hints[idx] = randomHint(argPool)
+		}
+	case 1:
+		// delete transaction
+		if len(hints) > 1 {
+			idx := backend.Rand.Intn(len(hints))
+			hints = append(hints[:idx], hints[idx+1:]...)
+		}
+	case 2:
+		// swap two transactions
+		i, j := backend.Rand.Intn(len(hints)), backend.Rand.Intn(len(hints))
+		hints[i], hints[j] = hints[j], hints[i]
+	case 3:
+		// regenerate one argument
+		hint := hints[backend.Rand.Intn(len(hints))]
+		if len(hint.Args) > 0 {
+			inputs := GetContractMethod(hint.Contract, hint.Method, backend.Metadata).Inputs
+			idx := backend.Rand.Intn(len(hint.Args))
+			key := methodKey(hint.Contract, hint.Method)
+			hint.Args[idx] = fillRecursively(inputs[idx].Type.Type, argPool, key).Interface()
+		}
+	case 4:
+		// change sender, random account is used
+		hints[backend.Rand.Intn(len(hints))].Sender = nil
+	case 5:
+		// change ether value, random amount is used for payable methods
+		hints[backend.Rand.Intn(len(hints))].Amount = nil
+	case 6:
+		// move transaction to the next timestamp, current timestamp of the pool
+		// is advanced only by GenTimestamp
+		hint := hints[backend.Rand.Intn(len(hints))]
+		if hint.Timestamp != nil {
+			if timestamp := argPool.TimestampAfter(hint.Timestamp); timestamp != nil {
+				hint.Timestamp = timestamp
+			}
+		}
+	case 7:
+		// call another instance of the contract, recorded address is kept if
+		// backend doesn't know any instance of it
+		hint := hints[backend.Rand.Intn(len(hints))]
+		if hasInstances(hint.Contract, backend) {
+			hint.Address = nil
+		}
+	}
+	return hints
+}
+
+// returns new sequence of hints derived from a random corpus entry,
+// or a fresh random sequence
+func (c *Corpus) nextSequence(backend *Backend, argPool *argpool.ArgPool) []*Hint {
+	var hints []*Hint
+	// generate fresh sequences occasionally, and always while corpus is empty
+	if c.Size() == 0 || backend.Rand.Intn(8) == 0 {
+		length := backend.Rand.Intn(maxFreshSequenceLength) + 1
+		for i := 0; i < length; i++ {
+			hints = append(hints, randomHint(argPool))
+		}
+		return hints
+	}
+
+	for _, input := range c.Entries[backend.Rand.Intn(c.Size())] {
+		hints = append(hints, hintFromInput(input))
+	}
+	mutations := backend.Rand.Intn(maxMutations) + 1
+	for i := 0; i < mutations; i++ {
+		hints = mutateSequence(backend, argPool, hints)
+	}
+	// time doesn't go backwards, keep timestamps non-decreasing
+	for i := 1; i < len(hints); i++ {
+		if hints[i-1].Timestamp == nil {
+			continue
+		}
+		if hints[i].Timestamp == nil || hints[i].Timestamp.Cmp(hints[i-1].Timestamp) < 0 {
+			hints[i].Timestamp = hints[i-1].Timestamp
+		}
+	}
+	return hints
+}
+
+// Executes one sequence from the corpus scheduler starting from the snapshot
+// state, the executed sequence is added to the corpus if it covered new
+// instructions, edges or branches. Returns true if fuzzing should be terminated.
+func FuzzSequence(backend *Backend, argPool *argpool.ArgPool, corpus *Corpus,
+	options *Options, result ResultsMap, optMode *OptMode) bool {
+
+	// timestamps are advanced after every 2048 transactions as in linear
+	// fuzzing, new transactions of the sequence use the current timestamp
+	GenTimestamp(backend, argPool)
+	hints := corpus.nextSequence(backend, argPool)
+	RevertBackend(backend)
+	covered := backend.Coverage()
+	for _, hint := range hints {
+		if Rec(backend, argPool, hint, options, result, optMode, 0) {
+			return true
+		}
+	}
+	if backend.Coverage() > covered && len(backend.TxSequence) > 0 {
+		corpus.Add(backend.TxSequence)
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// changes every *big.Int nested in value in place, as mutated hints would
+func setBigInts(v reflect.Value) {
+	if value, ok := v.Interface().(*big.Int); ok && value != nil {
+		value.SetInt64(-1)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			setBigInts(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setBigInts(v.Index(i))
+		}
+	}
+}
+
+func TestHintFromInputCopiesArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		args []interface{}
+	}{
+		{"no arguments", []interface{}{}},
+		{"big int", []interface{}{big.NewInt(7), common.HexToAddress("0x01"), uint8(3)}},
+		{"slice of big ints", []interface{}{[]*big.Int{big.NewInt(1), big.NewInt(2)}}},
+		{"array of big ints", []interface{}{[2]*big.Int{big.NewInt(3), big.NewInt(4)}}},
+		{"nested", []interface{}{[][2]*big.Int{{big.NewInt(5), nil}}, []byte{1, 2}, [32]byte{3}}},
+	}
+	for _, test := range tests {
+		args := test.args
+		input := &LastTxInput{
+			Contract:  "A",
+			Method:    "f",
+			Ether:     big.NewInt(10),
+			Input:     &args,
+			Timestamp: big.NewInt(1500000000),
+		}
+		want := fmt.Sprint(args, input.Ether, input.Timestamp)
+
+		hint := hintFromInput(input)
+		if !reflect.DeepEqual(hint.Args, args) {
+			t.Errorf("%v: got args %v, want %v", test.name, hint.Args, args)
+		}
+		// mutations of the hint must not change the corpus entry
+		setBigInts(reflect.ValueOf(hint.Args))
+		for _, arg := range hint.Args {
+			if value, ok := arg.([]byte); ok && len(value) > 0 {
+				value[0] = 0xff
+			}
+		}
+		if len(hint.Args) > 0 {
+			hint.Args[0] = nil
+		}
+		hint.Amount.SetInt64(-1)
+		hint.Timestamp.SetInt64(-1)
+		if got := fmt.Sprint(args, input.Ether, input.Timestamp); got != want {
+			t.Errorf("%v: mutated hint changed input to %v, want %v", test.name, got, want)
+		}
+	}
+}
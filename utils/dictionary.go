@@ -0,0 +1,172 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"math"
+	"math/big"
+	"strings"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/asm"
+)
+
+var (
+	maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	// multipliers of ether and time units in number literals
+	subdenominations = map[string]*big.Int{
+		"wei":     big.NewInt(1),
+		"gwei":    big.NewInt(1e9),
+		"szabo":   big.NewInt(1e12),
+		"finney":  big.NewInt(1e15),
+		"ether":   big.NewInt(1e18),
+		"seconds": big.NewInt(1),
+		"minutes": big.NewInt(60),
+		"hours":   big.NewInt(3600),
+		"days":    big.NewInt(86400),
+		"weeks":   big.NewInt(604800),
+		"years":   big.NewInt(31536000),
+	}
+)
+
+// parses value of number literal from solidity AST (decimal, hex or
+// scientific notation, with optional subdenomination)
+func parseNumberLiteral(value string, subdenomination string) (*big.Int, bool) {
+	value = strings.Replace(value, "_", "", -1)
+	var res *big.Int
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		var ok bool
+		if res, ok = new(big.Int).SetString(value[2:], 16); !ok {
+			return nil, false
+		}
+	} else {
+		rat, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return nil, false
+		}
+		if multiplier, found := subdenominations[subdenomination]; found {
+			rat.Mul(rat, new(big.Rat).SetInt(multiplier))
+		}
+		if !rat.IsInt() {
+			return nil, false
+		}
+		return rat.Num(), true
+	}
+	if multiplier, found := subdenominations[subdenomination]; found {
+		res.Mul(res, multiplier)
+	}
+	return res, true
+}
+
+// walks AST and collects values of Literal nodes
+func walkLiterals(node interface{}, numbers *[]*big.Int, strs *[]string) {
+	switch n := node.(type) {
+	case []interface{}:
+		for _, child := range n {
+			walkLiterals(child, numbers, strs)
+		}
+	case map[string]interface{}:
+		if n["nodeType"] == "Literal" {
+			value, _ := n["value"].(string)
+			subdenomination, _ := n["subdenomination"].(string)
+			switch n["kind"] {
+			case "number":
+				if number, ok := parseNumberLiteral(value, subdenomination); ok {
+					*numbers = append(*numbers, number)
+				}
+			case "string":
+				*strs = append(*strs, value)
+			}
+			return
+		}
+		for _, child := range n {
+			walkLiterals(child, numbers, strs)
+		}
+	}
+}
+
+// collects numeric and string literals from AST of contract artifact
+func collectASTLiterals(contract string, ast interface{}) {
+	var numbers []*big.Int
+	var strs []string
+	walkLiterals(ast, &numbers, &strs)
+	NumericLiterals[contract] = numbers
+	StringLiterals[contract] = strs
+}
+
+// adds constant and its neighbours to the pools of matching types
+func addDictionaryValue(argPool *argpool.ArgPool, value *big.Int) {
+	for _, delta := range []int64{0, -1, 1} {
+		val := new(big.Int).Add(value, big.NewInt(delta))
+		if val.Sign() < 0 || val.Cmp(maxUint256) > 0 {
+			continue
+		}
+		argPool.AddBigInt(val)
+		if !val.IsInt64() {
+			continue
+		}
+		argPool.AddInt64(val.Int64())
+		if val.Int64() <= math.MaxInt32 {
+			argPool.AddInt32(int32(val.Int64()))
+		}
+		if val.Int64() <= math.MaxInt16 {
+			argPool.AddInt16(int16(val.Int64()))
+		}
+	}
+	// values of address size
+	if value.BitLen() > 128 && value.BitLen() <= 160 {
+		argPool.AddAddress(common.BigToAddress(value))
+	}
+}
+
+// Seeds arg pool with constants from deployed bytecodes (PUSH1-PUSH32
+// immediates) and from number/string literals in AST of deployed contracts,
+// so that constants in require/if conditions are reachable
+func SeedDictionary(argPool *argpool.ArgPool, backend *Backend) {
+	for _, contract := range GetDeployedContractNames(backend) {
+		it := asm.NewInstructionIterator(getRuntimeCode(backend.Metadata, contract))
+		for it.Next() {
+			if !it.Op().IsPush() {
+				continue
+			}
+			addDictionaryValue(argPool, new(big.Int).SetBytes(it.Arg()))
+			if len(it.Arg()) == 32 {
+				argPool.AddBytes32(common.BytesToHash(it.Arg()))
+			}
+		}
+
+		for _, number := range NumericLiterals[contract] {
+			addDictionaryValue(argPool, number)
+		}
+		for _, str := range StringLiterals[contract] {
+			argPool.AddString(str)
+			// short strings are often compared as bytes32
+			if len(str) <= 32 {
+				var bytes32 [32]byte
+				copy(bytes32[:], str)
+				argPool.AddBytes32(bytes32)
+			}
+		}
+	}
+}
@@ -0,0 +1,232 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	// runtime code of EIP-1167 minimal proxy:
+	// <prefix> <implementation address> <suffix>
+	minimalProxyPrefix = hexutil.MustDecode("0x363d3d373d3d3d363d73")
+	minimalProxySuffix = hexutil.MustDecode("0x5af43d82803e903d91602b57fd5bf3")
+	// storage slots holding implementation address of upgradeable proxies:
+	// EIP-1967 (bytes32(uint256(keccak256("eip1967.proxy.implementation")) - 1))
+	// and zeppelinos (keccak256("org.zeppelinos.proxy.implementation"))
+	implementationSlots = []common.Hash{
+		common.HexToHash("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc"),
+		common.HexToHash("0x7050c9e0f4ca769c69bd3a8ef740bc37934f8e2c036e5a723fd8ee048ed3f8c3"),
+	}
+	// calldata used to check if fallback forwards calls with DELEGATECALL
+	probeCalldata = []byte{0xff, 0xff, 0xff, 0xff}
+)
+
+// returns implementation address if code is EIP-1167 minimal proxy
+func getMinimalProxyImplementation(code []byte) (common.Address, bool) {
+	if len(code) != len(minimalProxyPrefix)+common.AddressLength+len(minimalProxySuffix) {
+		return common.Address{}, false
+	}
+	if !bytes.HasPrefix(code, minimalProxyPrefix) || !bytes.HasSuffix(code, minimalProxySuffix) {
+		return common.Address{}, false
+	}
+	implementation := code[len(minimalProxyPrefix) : len(minimalProxyPrefix)+common.AddressLength]
+	return common.BytesToAddress(implementation), true
+}
+
+// returns implementation of upgradeable proxy, implementation is read from
+// well-known storage slots, otherwise fallback is called (with a sender that
+// is not admin) and the target of forwarding DELEGATECALL is returned
+func (b *Backend) getProxyImplementation(address common.Address) (common.Address, bool) {
+	for _, slot := range implementationSlots {
+		implementation := common.BytesToAddress(b.StateDB.GetState(address, slot).Bytes())
+		if implementation != (common.Address{}) && b.StateDB.GetCodeSize(implementation) > 0 {
+			return implementation, true
+		}
+	}
+
+	tracer := vm.NewStructLogger(&vm.LogConfig{})
+	b.SimulateCall(common.Address{}, &address, new(big.Int), probeCalldata,
+		uint64(*maxGasPool), tracer,
+	)
+	for _, structLog := range tracer.StructLogs() {
+		if structLog.Depth != 1 || structLog.Op != vm.DELEGATECALL {
+			continue
+		}
+		// stack: gas, address, input offset, input size, ...
+		st := structLog.Stack
+		if len(st) < 4 || !st[len(st)-3].IsUint64() || st[len(st)-4].Uint64() != uint64(len(probeCalldata)) {
+			continue
+		}
+		offset := st[len(st)-3].Uint64()
+		if offset+uint64(len(probeCalldata)) > uint64(len(structLog.Memory)) {
+			continue
+		}
+		if !bytes.Equal(structLog.Memory[offset:offset+uint64(len(probeCalldata))], probeCalldata) {
+			continue
+		}
+		implementation := common.BigToAddress(st[len(st)-2])
+		if b.StateDB.GetCodeSize(implementation) > 0 {
+			return implementation, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// identifies contract at address by its runtime code, proxies are identified
+// by the code of their implementation which is returned as well
+func (b *Backend) identifyContract(address common.Address) (string, *common.Address, bool) {
+	code := b.StateDB.GetCode(address)
+	if len(code) == 0 {
+		return "", nil, false
+	}
+	implementation, ok := getMinimalProxyImplementation(code)
+	if !ok {
+		implementation, ok = b.getProxyImplementation(address)
+	}
+	if ok {
+		name, found := GetContractNameByCode(b.StateDB.GetCode(implementation), b.Metadata)
+		if found {
+			return name, &implementation, true
+		}
+	}
+	name, found := GetContractNameByCode(code, b.Metadata)
+	return name, nil, found
+}
+
+func (b *Backend) hasInstance(contract string, address common.Address) bool {
+	if b.DeployedContracts[contract] == nil {
+		return false
+	}
+	for _, instance := range b.DeployedContracts[contract].Addresses {
+		if instance == address {
+			return true
+		}
+	}
+	return false
+}
+
+// returns methods of contract that should be fuzzed according to config,
+// contract is not fuzzed at all if nil is returned
+func getFuzzedMethods(contract string, metadata string) []string {
+	if contract == "Migrations" || Libraries[contract] {
+		return nil
+	}
+	config := GetConfig(metadata)[contract]
+	if config.IgnoreAll {
+		return nil
+	}
+	methods := GetSortedMethods(contract, metadata)
+	for _, method := range config.IgnoredFunctions {
+		methods = deleteFromSlice(method, methods)
+	}
+	return methods
+}
+
+// registers contract created at address as instance of its contract,
+// if fuzzTarget is set contract is immediately added to fuzz targets
+// (otherwise methods are filled after deployment in NewBackend)
+func (b *Backend) addCreatedContract(address common.Address, fuzzTarget bool) {
+	name, implementation, found := b.identifyContract(address)
+	if !found {
+		log.Trace(fmt.Sprintf("Created contract at %x was not recognized", address))
+		return
+	}
+	if implementation != nil {
+		b.Proxies[address] = *implementation
+	}
+	b.addInstance(name, address, fuzzTarget)
+}
+
+// registers address as instance of contract name, unknown contract becomes
+// fuzz target if fuzzTarget is set and some of its methods are fuzzed
+func (b *Backend) addInstance(name string, address common.Address, fuzzTarget bool) {
+	if b.hasInstance(name, address) {
+		return
+	}
+	if b.DeployedContracts[name] == nil {
+		methods := make([]string, 0)
+		if fuzzTarget {
+			methods = getFuzzedMethods(name, b.Metadata)
+			if len(methods) == 0 {
+				return
+			}
+			b.ContractsList = append(b.ContractsList, name)
+		}
+		b.DeployedContracts[name] = &Contract{
+			Addresses: make([]common.Address, 0),
+			Methods:   methods,
+		}
+	}
+	b.DeployedContracts[name].Addresses = append(b.DeployedContracts[name].Addresses, address)
+	log.Debug(fmt.Sprintf("Created contract: %v with address: %x", name, address))
+}
+
+// detects proxies among deployed contracts whose implementation was set after
+// their creation, proxies are fuzzed as instances of their implementation
+func (b *Backend) detectProxies() {
+	type proxy struct {
+		contract       string
+		address        common.Address
+		implementation common.Address
+	}
+	var proxies []proxy
+	for _, contract := range GetDeployedContractNames(b) {
+		for _, address := range b.DeployedContracts[contract].Addresses {
+			if _, found := b.Proxies[address]; found {
+				continue
+			}
+			if implementation, ok := b.getProxyImplementation(address); ok {
+				proxies = append(proxies, proxy{contract, address, implementation})
+			}
+		}
+	}
+	for _, p := range proxies {
+		name, found := GetContractNameByCode(b.StateDB.GetCode(p.implementation), b.Metadata)
+		if !found {
+			continue
+		}
+		deployed := b.DeployedContracts[p.contract]
+		for i, address := range deployed.Addresses {
+			if address == p.address {
+				deployed.Addresses = append(deployed.Addresses[:i], deployed.Addresses[i+1:]...)
+				break
+			}
+		}
+		if len(deployed.Addresses) == 0 {
+			delete(b.DeployedContracts, p.contract)
+		}
+		b.Proxies[p.address] = p.implementation
+		b.addCreatedContract(p.address, false)
+		log.Debug(fmt.Sprintf("Proxy %x of %v with implementation %x", p.address, name,
+			p.implementation,
+		))
+	}
+}
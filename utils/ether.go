@@ -0,0 +1,144 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// accounts of fuzzer that were not used during deployment and attacker
+// contracts, they don't own ether of deployed contracts
+func (b *Backend) isUnprivileged(address common.Address) bool {
+	if b.isAttacker(address) {
+		return true
+	}
+	for _, account := range ReadAccounts(b.Metadata) {
+		if account.Address == address {
+			return !account.Used
+		}
+	}
+	return false
+}
+
+// ether sent by CALL, known to be received once the call returned successfully
+type etherTransfer struct {
+	to       common.Address
+	depth    int
+	location *Location
+}
+
+// Tracks ether sent by CALL and SELFDESTRUCT in a trace. Received maps
+// unprivileged accounts to the last opcode that sent them ether, Sent
+// contains contracts whose code sent ether.
+type etherTracker struct {
+	backend  *Backend
+	pending  []etherTransfer
+	Received map[common.Address]*Location
+	Sent     map[common.Address]bool
+}
+
+func newEtherTracker(backend *Backend) *etherTracker {
+	return &etherTracker{
+		backend:  backend,
+		Received: make(map[common.Address]*Location),
+		Sent:     make(map[common.Address]bool),
+	}
+}
+
+// called for each log, before the opcode is handled
+func (t *etherTracker) Step(structLog vm.StructLog) {
+	for len(t.pending) > 0 {
+		transfer := t.pending[len(t.pending)-1]
+		if structLog.Depth > transfer.depth {
+			return
+		}
+		t.pending = t.pending[:len(t.pending)-1]
+		// result of CALL is on top of the stack in the calling frame, frame
+		// of the call ended if depth is lower
+		st := structLog.Stack
+		if structLog.Depth != transfer.depth || len(st) == 0 || st[len(st)-1].Sign() == 0 {
+			continue
+		}
+		t.Sent[transfer.location.Address] = true
+		if t.backend.isUnprivileged(transfer.to) {
+			t.Received[transfer.to] = transfer.location
+		}
+	}
+}
+
+// called for CALL and SELFDESTRUCT opcodes executed outside of init code
+func (t *etherTracker) Send(structLog vm.StructLog, location *Location) {
+	st := structLog.Stack
+	switch structLog.Op {
+	case vm.CALL:
+		to := common.BigToAddress(st[len(st)-2])
+		if st[len(st)-3].Sign() > 0 {
+			t.pending = append(t.pending, etherTransfer{to, structLog.Depth, location})
+		}
+	case vm.SELFDESTRUCT:
+		to := common.BigToAddress(st[len(st)-1])
+		t.Sent[location.Address] = true
+		if t.backend.isUnprivileged(to) {
+			t.Received[to] = location
+		}
+	}
+}
+
+// Unprivileged accounts are controlled by the same attacker (ether sent by
+// an account through an attacker contract may be paid out to the attacker
+// contract), so their balances are summed up. Returns violation if they own
+// more ether than at the start of the sequence and one of them received ether
+// in the last transaction, gas price of transactions is zero, so they
+// received more than they deposited.
+func (b *Backend) etherLeak() (Violation, bool) {
+	received := b.LastTxRes.EtherReceived
+	if b.snapshot == nil || len(received) == 0 {
+		return Violation{}, false
+	}
+	var addresses []common.Address
+	for _, account := range ReadAccounts(b.Metadata) {
+		if !account.Used {
+			addresses = append(addresses, account.Address)
+		}
+	}
+	addresses = append(addresses, b.Attackers...)
+	gain := new(big.Int)
+	var receiver *common.Address
+	for i, address := range addresses {
+		gain.Add(gain, b.StateDB.GetBalance(address))
+		gain.Sub(gain, b.snapshot.GetBalance(address))
+		if _, found := received[address]; found && receiver == nil {
+			receiver = &addresses[i]
+		}
+	}
+	if gain.Sign() <= 0 || receiver == nil {
+		return Violation{}, false
+	}
+	return Violation{EtherLeakViolation,
+		fmt.Sprintf("unprivileged accounts gained %v wei, sent to %v", gain, receiver.Hex()),
+		received[*receiver],
+	}, true
+}
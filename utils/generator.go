@@ -22,12 +22,12 @@
 package utils
 
 import (
-	"crypto/ecdsa"
 	"math/big"
 
 	"fuzzer/argpool"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -39,6 +39,15 @@ type Hint struct {
 	Sender   *common.Address
 	// generate fallback transaction
 	Fallback bool
+	// block timestamp, timestamp heuristic is used if not specified
+	Timestamp *big.Int
+	// instance of contract, random instance is used if not specified
+	Address *common.Address
+	// attacker contract through which transaction is sent (used together
+	// with Sender) and call with which attackers re-enter the contract
+	Via           *common.Address
+	ReentryMethod string
+	Reentry       []byte
 }
 
 func getRandomAmountFromAddress(address common.Address, argPool *argpool.ArgPool, backend *Backend) *big.Int {
@@ -56,46 +65,71 @@ func GenTransaction(backend *Backend, argPool *argpool.ArgPool, hint *Hint) *typ
 		method        string
 		args          []interface{}
 		senderAddress *common.Address
-		senderKey     *ecdsa.PrivateKey
 	)
+	// hint without recorded address can't call contract without known
+	// instances, random contract is called instead
+	if hint.Contract != "" && hint.Address == nil && !hasInstances(hint.Contract, backend) {
+		hint.Contract, hint.Method, hint.Args, hint.Fallback = "", "", nil, false
+		hint.ReentryMethod, hint.Reentry = "", nil
+	}
 	if hint != nil && hint.Contract != "" {
 		contract = hint.Contract
 	} else {
 		contract = GetRandomContract(backend)
 	}
 
-	if hint != nil && hint.Method != "" {
+	if hint.Fallback {
+		method = ""
+	} else if hint != nil && hint.Method != "" {
 		method = hint.Method
 	} else {
 		method = GetRandomMethod(contract, backend)
 	}
-	if hint.Fallback {
-		method = ""
-	}
 
-	contractAddress := backend.DeployedContracts[contract].Addresses[0]
+	var contractAddress common.Address
+	if hint != nil && hint.Address != nil {
+		contractAddress = *hint.Address
+	} else {
+		contractAddress = GetRandomInstance(contract, backend)
+	}
 	methodABI := GetContractMethod(contract, method, backend.Metadata)
 	if hint != nil && hint.Args != nil {
 		args = hint.Args
 	} else {
-		args = ConstructArgs(methodABI.Inputs, argPool)
+		args = ConstructArgs(methodABI.Inputs, argPool, methodKey(contract, method))
 	}
 
-	input := GetCallBytecode(contract, method, args, backend.Metadata)
-
+	var via *common.Address
 	if hint != nil && hint.Sender != nil {
 		senderAddress = hint.Sender
-		senderKey = GetKeyFromAddress(*senderAddress)
+		via = hint.Via
 	} else {
-		randAccount := GetRandAccount(backend.Metadata)
+		randAccount := GetRandAccount(backend.Metadata, backend.Rand)
 		senderAddress = &randAccount.Address
-		senderKey = randAccount.Key
+		// some transactions are sent through attacker contracts, so that
+		// the contract calls back into code controlled by fuzzer
+		if len(backend.Attackers) > 0 && backend.Rand.Intn(4) == 0 {
+			attacker := backend.Attackers[backend.Rand.Intn(len(backend.Attackers))]
+			via = &attacker
+		}
+	}
+	reentryMethod, reentry := hint.ReentryMethod, hexutil.Bytes(hint.Reentry)
+	if reentry == nil {
+		reentryMethod, reentry = genReentry(backend, argPool, contract)
 	}
 	// only transfer ether if method is payable
 	amount := big.NewInt(0)
 	// for each payable function send ether once to cover that case in bytecode
 	if hint != nil && hint.Amount != nil {
 		amount = hint.Amount
+		// random sender (e.g. changed by corpus mutation) may not afford
+		// the amount of the original sender
+		if hint.Sender == nil {
+			balance := backend.StateDB.GetBalance(*senderAddress)
+			if amount.Cmp(balance) > 0 {
+				amount = new(big.Int).Set(balance)
+			}
+		}
 	} else {
 		if IsPayable(contract, method) {
 			amount = getRandomAmountFromAddress(*senderAddress, argPool, backend)
@@ -106,23 +140,49 @@ func GenTransaction(backend *Backend, argPool *argpool.ArgPool, hint *Hint) *typ
 	hint.Contract = contract
 	hint.Method = method
 	hint.Amount = amount
+	hint.Address = &contractAddress
 
-	tx := types.NewTransaction(backend.StateDB.GetNonce(*senderAddress),
-		contractAddress,
-		amount,
-		uint64(*maxGasPool),
-		big.NewInt(0),
-		input,
-	)
-	signed_tx, _ := types.SignTx(tx, types.HomesteadSigner{}, senderKey)
 	backend.LastTxIn = &LastTxInput{
 		Contract: contract,
 		Method:   method,
 		Const:    methodABI.Const,
-		Ether:    amount,
-		Input:    &args,
-		OutArgs:  methodABI.Outputs,
-		Sender:   senderAddress,
+		// amount may be a value of the arg pool, recorded input keeps a copy
+		Ether:   new(big.Int).Set(amount),
+		Input:   &args,
+		OutArgs: methodABI.Outputs,
+		Sender:  senderAddress,
+		To:      &contractAddress,
+
+		Via:           via,
+		ReentryMethod: reentryMethod,
+		Reentry:       reentry,
+	}
+	return BuildTransaction(backend, backend.LastTxIn)
+}
+
+// encodes and signs transaction described by input, nonce is taken from
+// current state of the sender, so inputs can be applied in any order
+func BuildTransaction(backend *Backend, input *LastTxInput) *types.Transaction {
+	if input.To == nil {
+		contractAddress := backend.DeployedContracts[input.Contract].Addresses[0]
+		input.To = &contractAddress
+	}
+	input.Payload = GetCallBytecode(input.Contract, input.Method, *input.Input, backend.Metadata)
+	backend.armAttackers(input.Reentry)
+
+	to, data := *input.To, []byte(input.Payload)
+	// attacker forwards call data after the address of the contract
+	if input.Via != nil {
+		to = *input.Via
+		data = append(common.LeftPadBytes(input.To.Bytes(), 32), data...)
 	}
+	tx := types.NewTransaction(backend.StateDB.GetNonce(*input.Sender),
+		to,
+		input.Ether,
+		uint64(*maxGasPool),
+		big.NewInt(0),
+		data,
+	)
+	signed_tx, _ := types.SignTx(tx, types.HomesteadSigner{}, GetKeyFromAddress(*input.Sender))
 	return signed_tx
 }
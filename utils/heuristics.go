@@ -26,7 +26,6 @@ import (
 	"math"
 	"math/big"
 	"strings"
-	"time"
 
     "encoding/json"
     "io/ioutil"
@@ -46,10 +45,10 @@ type OptMode struct {
 	RetryHalfEther bool
 	// retry failed transaction with different sender
 	RetryDiffSender bool
-	// snapshot state and if "too many transactions fail" revert the state
-	SnapshotsEnabled bool
 	// process statistics, which method was called how many times and rate of failure
 	GenStatistics bool
+	// shrink sequences of transactions that trigger violations
+	MinimizeSequences bool
 }
 
 func (o *OptMode) SetFlag(optFlag int) {
@@ -59,12 +58,13 @@ func (o *OptMode) SetFlag(optFlag int) {
 	if optFlag&2 != 0 {
 		o.RetryDiffSender = true
 	}
-	if optFlag&4 != 0 {
-		o.SnapshotsEnabled = true
-	}
+	// bit 4 used to enable snapshots, sequences always start from the snapshot
 	if optFlag&8 != 0 {
 		o.GenStatistics = true
 	}
+	if optFlag&16 != 0 {
+		o.MinimizeSequences = true
+	}
 }
 
 func GenTimestamp(backend *Backend, argPool *argpool.ArgPool) *big.Int {
@@ -87,59 +87,52 @@ func SaveJson(filename string, backend *Backend){
 }
 
 
+
 func Rec(backend *Backend, argPool *argpool.ArgPool, hint *Hint,
 		options *Options, result ResultsMap, optMode *OptMode, depth int) bool {
 
 	header := GetDefaultHeader(backend)
-	header.Time = GenTimestamp(backend, argPool)
+	if hint.Timestamp != nil {
+		header.Time = hint.Timestamp
+	} else {
+		header.Time = GenTimestamp(backend, argPool)
+	}
 	tx := GenTransaction(backend, argPool, hint)
 	desc := backend.LastTxIn
 	if result[desc.Contract] == nil {
 		result[desc.Contract] = make(map[string]string)
 	}
 	log.Debug(fmt.Sprintf(fmt.Sprintf("%v. Fuzzing:\t%+v", backend.TxCount+1, PrettyPrint(desc))))
-	backend.CommitTransaction(tx, argPool, options, header)
-
-	log.Debug(fmt.Sprintf("output: %+v\n", backend.LastTxRes.StructLogger.Output()))
-
-	reverted := backend.LastTxRes.RevertAtDepth == 1
-	// If overflow happens and transaction is not reverted
-	if backend.LastTxRes.Overflow != "" && !reverted {
-		log.Debug("Overflow detected")
-        log.Debug(backend.LastTxRes.Overflow)
-        SaveJson(fmt.Sprintf("/tmp/overflow_%v.json", backend.TxCount), backend)
-		s := fmt.Sprintf("%v: Overflow", desc.Method)
-		result[desc.Contract][s] = backend.LastTxRes.Overflow
-	}
-
-	if backend.LastTxRes.AssertionAtDepth != -1 {
-		log.Debug(fmt.Sprintf("Assertion failure.\ttook: %v transactions", backend.TxCount))
-		s := fmt.Sprintf("%v: %v", desc.Method, "AssertionFailure")
-        SaveJson(fmt.Sprintf("/tmp/assertion_%v.json", backend.TxCount), backend)
-		result[desc.Contract][s] = ""
+	// transaction can't be applied, e.g. sender can't pay the ether
+	if err, _ := backend.CommitTransaction(tx, argPool, options, header); err != nil {
+		return false
 	}
 
-	if strings.Index(desc.Method, "fuzz_always_true") == 0 {
-
-		if !reverted && len(backend.LastTxRes.StructLogger.Output()) == 32 && backend.LastTxRes.StructLogger.Output()[31] != 1 {
-			log.Debug(fmt.Sprintf("property violation, not always true.\ttook: %v transactions", backend.TxCount))
-			s := fmt.Sprintf("%v: %v", desc.Method, "Property violation")
-            SaveJson("/tmp/violation.json", backend)
+	log.Debug(fmt.Sprintf("output: %+v\n", backend.LastTxRes.StructLogger.Output()))
 
-			result[desc.Contract][s] = ""
-			return true
+	terminate := false
+	for _, violation := range GetViolations(backend) {
+		log.Debug(fmt.Sprintf("%v detected.\ttook: %v transactions %v",
+			violation.Kind, backend.TxCount, violation.Description,
+		))
+		finding := NewFinding(backend, violation)
+		// the same violation is saved (and minimized) only once
+		saved := backend.getFinding(finding)
+		if saved != nil {
+			saved.Occurrences++
+		} else {
+			saved = backend.saveFinding(finding, argPool, optMode.MinimizeSequences)
 		}
-
-		// stop fuzzing once a fuzz_always_true function reverts
-		if reverted {
-			log.Debug(fmt.Sprintf("revert in fuzz function.\ttook: %v transactions", backend.TxCount))
-			s := fmt.Sprintf("%v: %v", desc.Method, "Revert in fuzz function")
-            SaveJson("/tmp/reverted_fuzz.json", backend)
-
-			result[desc.Contract][s] = ""
-			return true
+		s := fmt.Sprintf("%v: %v", desc.Method, violation.Kind)
+		result[desc.Contract][s] = strings.TrimSpace(violation.Description + saved.SourceSuffix())
+		// stop fuzzing once a fuzz_always_true function is violated or reverts
+		if violation.Kind == PropertyViolation || violation.Kind == RevertInFuzzViolation {
+			terminate = true
 		}
 	}
+	if terminate {
+		return true
+	}
 
 	if optMode.GenStatistics {
 		failed := backend.LastTxRes.RevertAtDepth == 1
@@ -158,9 +151,11 @@ func Rec(backend *Backend, argPool *argpool.ArgPool, hint *Hint,
 	if optMode.RetryHalfEther {
 		// half the value of ether for transaction
 		if tx.Value().Sign() == 1 {
-			// divide by 2 (bitwise right shift by 1)
-			hint.Amount.Rsh(hint.Amount, 1)
+			// divide by 2 (bitwise right shift by 1), amount may come from the
+			// arg pool so it is not modified in place
+			hint.Amount = new(big.Int).Rsh(hint.Amount, 1)
 			hint.Sender = backend.LastTxIn.Sender
+			hint.Via = backend.LastTxIn.Via
 			Rec(backend, argPool, hint, options, result, optMode, depth+1)
 		}
 	}
@@ -175,19 +170,17 @@ func Rec(backend *Backend, argPool *argpool.ArgPool, hint *Hint,
 	return false
 }
 
-var defaultHeader *types.Header
-
 func GetDefaultHeader(b *Backend) *types.Header {
-	if defaultHeader == nil {
-		defaultHeader = &types.Header{
+	if b.defaultHeader == nil {
+		b.defaultHeader = &types.Header{
 			Coinbase:   coinBase,
 			ParentHash: b.BlockChain.CurrentBlock().Hash(),
 			Number:     big.NewInt(1),
 			GasLimit:   math.MaxUint64,
 			Difficulty: big.NewInt(int64(1)),
 			Extra:      nil,
-			Time:       big.NewInt(time.Now().Unix()),
+			Time:       big.NewInt(b.StartTime),
 		}
 	}
-	return defaultHeader
+	return b.defaultHeader
 }
@@ -0,0 +1,132 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// kind of locked ether in results and reports, it is not a violation of a
+// transaction
+const LockedEtherKind = "Locked ether"
+
+// contract that accepted ether which was never observed to leave it
+type LockedEther struct {
+	Contract string `json:"contract"`
+	Source   string `json:"source,omitempty"`
+	// instances that held ether
+	Instances []common.Address `json:"instances"`
+	// payable methods, fallback function is "fallback"
+	Payable []string `json:"payable"`
+}
+
+// returns balances of instances of deployed contracts
+func (b *Backend) contractBalances() map[common.Address]*big.Int {
+	balances := make(map[common.Address]*big.Int)
+	for _, contract := range b.DeployedContracts {
+		for _, address := range contract.Addresses {
+			balances[address] = b.StateDB.GetBalance(address)
+		}
+	}
+	return balances
+}
+
+// records which instances hold ether after the last transaction and which
+// sent ether out (balance decreased or code sent ether in the trace of
+// successful transaction), balances were taken before the transaction
+func (b *Backend) updateEtherFlows(balances map[common.Address]*big.Int) {
+	if b.LastTxRes.Receipt.Status == types.ReceiptStatusSuccessful {
+		for address := range b.LastTxRes.EtherSent {
+			b.EtherSent[address] = true
+		}
+	}
+	for address, balance := range b.contractBalances() {
+		if balance.Sign() > 0 {
+			b.EtherHeld[address] = true
+		}
+		if before, found := balances[address]; found && balance.Cmp(before) < 0 {
+			b.EtherSent[address] = true
+		}
+	}
+}
+
+// adds ether flows observed by other backend
+func (b *Backend) mergeEtherFlows(other *Backend) {
+	for address := range other.EtherHeld {
+		b.EtherHeld[address] = true
+	}
+	for address := range other.EtherSent {
+		b.EtherSent[address] = true
+	}
+}
+
+// Returns fuzzed contracts that held ether but never sent any out (through
+// any of their instances), e.g. contracts with payable methods and no way to
+// withdraw. Ether may be forced into contracts without payable methods.
+func (b *Backend) GetLockedEther() []*LockedEther {
+	locked := make([]*LockedEther, 0)
+	for _, contract := range GetDeployedContractNames(b) {
+		if contract == "Migrations" || len(b.DeployedContracts[contract].Methods) == 0 {
+			continue
+		}
+		var instances []common.Address
+		sent := false
+		for _, address := range b.DeployedContracts[contract].Addresses {
+			if b.EtherHeld[address] {
+				instances = append(instances, address)
+			}
+			sent = sent || b.EtherSent[address]
+		}
+		if len(instances) == 0 || sent {
+			continue
+		}
+		payable := make([]string, 0)
+		if artifact := getArtifact(b.Metadata, contract); artifact != nil {
+			payable = append(payable, artifact.PayableEntries...)
+		}
+		locked = append(locked, &LockedEther{
+			Contract:  contract,
+			Source:    GetSourcePath(b.Metadata, contract),
+			Instances: instances,
+			Payable:   payable,
+		})
+	}
+	return locked
+}
+
+// returns description of locked ether, e.g. "held by 0x.., payable: deposit"
+func (l *LockedEther) String() string {
+	var instances []string
+	for _, address := range l.Instances {
+		instances = append(instances, address.Hex())
+	}
+	payable := "none"
+	if len(l.Payable) > 0 {
+		payable = strings.Join(l.Payable, ", ")
+	}
+	return fmt.Sprintf("held by %v, payable: %v", strings.Join(instances, ", "), payable)
+}
@@ -0,0 +1,355 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// maximal number of sequence executions spent on shrinking one finding
+const maxShrinkRuns = 5000
+
+// maximal number of arg pool values tried as simpler value of one argument
+const maxPoolCandidates = 16
+
+type shrinker struct {
+	backend *Backend
+	argPool *argpool.ArgPool
+	finding *Finding
+	runs    int
+	// values of the arg pool after the finding was reproduced
+	contents *argpool.Contents
+	// checks whether sequence triggers the violation of the finding
+	replay func(txs []*LastTxInput) ([]*LastTxInput, bool)
+}
+
+func newShrinker(backend *Backend, argPool *argpool.ArgPool, finding *Finding) *shrinker {
+	s := &shrinker{backend: backend, argPool: argPool, finding: finding}
+	s.replay = s.replayFromSnapshot
+	return s
+}
+
+// checks whether the violation of the finding is triggered by transactions,
+// returned sequence ends with the transaction that triggered it
+func (s *shrinker) reproduces(txs []*LastTxInput) ([]*LastTxInput, bool) {
+	s.runs++
+	return s.replay(txs)
+}
+
+// executes transactions starting from the snapshot state
+func (s *shrinker) replayFromSnapshot(txs []*LastTxInput) ([]*LastTxInput, bool) {
+	RevertBackend(s.backend)
+	for i, input := range txs {
+		if err := ReplayTransaction(s.backend, s.argPool, input, &Options{}); err != nil {
+			return nil, false
+		}
+		for _, violation := range GetViolations(s.backend) {
+			if s.finding.Matches(input, violation) {
+				return txs[:i+1], true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *shrinker) exhausted() bool {
+	return s.runs >= maxShrinkRuns
+}
+
+// tries to remove chunks of transactions, chunk size is halved until single
+// transactions are removed
+func (s *shrinker) removeTransactions(txs []*LastTxInput) []*LastTxInput {
+	for chunk := len(txs) / 2; chunk >= 1 && !s.exhausted(); chunk /= 2 {
+		for start := 0; start < len(txs) && !s.exhausted(); {
+			end := start + chunk
+			if end > len(txs) {
+				end = len(txs)
+			}
+			candidate := make([]*LastTxInput, 0, len(txs)-(end-start))
+			candidate = append(candidate, txs[:start]...)
+			candidate = append(candidate, txs[end:]...)
+			if reduced, ok := s.reproduces(candidate); ok {
+				txs = reduced
+				continue
+			}
+			start = end
+		}
+	}
+	return txs
+}
+
+// replaces transaction at idx with the modified one if violation still
+// reproduces
+func (s *shrinker) tryReplace(txs []*LastTxInput, idx int, input *LastTxInput) ([]*LastTxInput, bool) {
+	candidate := make([]*LastTxInput, len(txs))
+	copy(candidate, txs)
+	candidate[idx] = input
+	if reduced, ok := s.reproduces(candidate); ok {
+		return reduced, true
+	}
+	return txs, false
+}
+
+// simplifies arguments of every transaction towards zero/small values
+func (s *shrinker) simplifyArguments(txs []*LastTxInput) []*LastTxInput {
+	for idx := 0; idx < len(txs) && !s.exhausted(); idx++ {
+		for arg := 0; arg < len(*txs[idx].Input) && !s.exhausted(); arg++ {
+			for {
+				args := *txs[idx].Input
+				current := reflect.ValueOf(args[arg])
+				pool := poolIntegers(s.contents, current.Type(), methodKey(txs[idx].Contract, txs[idx].Method))
+				simplified := false
+				for _, value := range simplerValues(current, pool) {
+					input := copyInput(txs[idx])
+					(*input.Input)[arg] = value.Interface()
+					var ok bool
+					if txs, ok = s.tryReplace(txs, idx, input); ok {
+						simplified = true
+						break
+					}
+				}
+				// sequence could have been cut after the replaced transaction
+				if !simplified || s.exhausted() || idx >= len(txs) {
+					break
+				}
+			}
+			if idx >= len(txs) {
+				break
+			}
+		}
+	}
+	return txs
+}
+
+// lowers ether amounts sent with transactions
+func (s *shrinker) lowerEther(txs []*LastTxInput) []*LastTxInput {
+	for idx := 0; idx < len(txs) && !s.exhausted(); idx++ {
+		for txs[idx].Ether.Sign() > 0 && !s.exhausted() {
+			simplified := false
+			for _, amount := range []*big.Int{big.NewInt(0), new(big.Int).Rsh(txs[idx].Ether, 1)} {
+				input := copyInput(txs[idx])
+				input.Ether = amount
+				var ok bool
+				if txs, ok = s.tryReplace(txs, idx, input); ok {
+					simplified = true
+					break
+				}
+			}
+			if !simplified || idx >= len(txs) {
+				break
+			}
+		}
+	}
+	return txs
+}
+
+// Shrinks the sequence of transactions of the finding: removes transactions,
+// simplifies arguments and lowers ether amounts while the same violation
+// (same kind, contract, method and opcode) still reproduces from the snapshot.
+// State of the backend is restored afterwards so that fuzzing can continue.
+func MinimizeFinding(backend *Backend, argPool *argpool.ArgPool, finding *Finding) *Finding {
+	state := backend.StateDB.Copy()
+	txSequence, lastTxIn, lastTxRes, txCount := backend.TxSequence, backend.LastTxIn, backend.LastTxRes, backend.TxCount
+	grants, created := backend.Grants, backend.Created
+	defer func() {
+		*backend.StateDB = *state
+		backend.TxSequence, backend.LastTxIn, backend.LastTxRes, backend.TxCount = txSequence, lastTxIn, lastTxRes, txCount
+		backend.Grants, backend.Created = grants, created
+	}()
+
+	s := newShrinker(backend, argPool, finding)
+	txs, ok := s.reproduces(finding.Transactions)
+	if !ok {
+		log.Warn(fmt.Sprintf("%v in %v.%v doesn't reproduce from snapshot, skipping minimization",
+			finding.Kind, finding.Contract, finding.Method,
+		))
+		return finding
+	}
+	// includes operands of comparisons executed by the sequence
+	s.contents = argPool.Contents()
+	// simplified arguments can make more transactions redundant
+	for size := len(txs) + 1; len(txs) < size && !s.exhausted(); {
+		size = len(txs)
+		txs = s.removeTransactions(txs)
+		txs = s.simplifyArguments(txs)
+		txs = s.lowerEther(txs)
+	}
+	log.Debug(fmt.Sprintf("Minimized sequence of %v from %v to %v transactions (%v runs)",
+		finding.Kind, len(finding.Transactions), len(txs), s.runs,
+	))
+
+	minimized := *finding
+	minimized.Transactions = txs
+	return &minimized
+}
+
+// returns copy of input with copied arguments, so that they can be modified
+func copyInput(input *LastTxInput) *LastTxInput {
+	cpy := *input
+	args := make([]interface{}, len(*input.Input))
+	copy(args, *input.Input)
+	cpy.Input = &args
+	return &cpy
+}
+
+// returns integers of the arg pool that fit type T and operands of
+// comparisons executed by method, sorted by absolute value
+func poolIntegers(contents *argpool.Contents, T reflect.Type, method string) []*big.Int {
+	var values []*big.Int
+	switch T.Kind() {
+	case reflect.Int8, reflect.Uint8:
+		for _, item := range contents.Int8 {
+			values = append(values, big.NewInt(int64(item)))
+		}
+	case reflect.Int16, reflect.Uint16:
+		for _, item := range contents.Int16 {
+			values = append(values, big.NewInt(int64(item)))
+		}
+	case reflect.Int32, reflect.Uint32:
+		for _, item := range contents.Int32 {
+			values = append(values, big.NewInt(int64(item)))
+		}
+	case reflect.Int64, reflect.Uint64:
+		for _, item := range contents.Int64 {
+			values = append(values, big.NewInt(item))
+		}
+	case reflect.Ptr:
+		if T != BigIntType {
+			return nil
+		}
+		values = append(values, contents.BigInts...)
+	default:
+		return nil
+	}
+	values = append(values, contents.Cmp[method]...)
+	// ties are ordered by value, so that order doesn't depend on the pool
+	sort.Slice(values, func(i, j int) bool {
+		if c := values[i].CmpAbs(values[j]); c != 0 {
+			return c < 0
+		}
+		return values[i].Cmp(values[j]) < 0
+	})
+	return values
+}
+
+// converts integer to value of type T, returns false if it doesn't fit
+func integerValue(x *big.Int, T reflect.Type) (reflect.Value, bool) {
+	if T == BigIntType {
+		return reflect.ValueOf(new(big.Int).Set(x)), true
+	}
+	val := reflect.New(T).Elem()
+	switch T.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if !x.IsInt64() || val.OverflowInt(x.Int64()) {
+			return val, false
+		}
+		val.SetInt(x.Int64())
+	default:
+		if x.Sign() < 0 || !x.IsUint64() || val.OverflowUint(x.Uint64()) {
+			return val, false
+		}
+		val.SetUint(x.Uint64())
+	}
+	return val, true
+}
+
+// returns candidates that are strictly simpler than value, simplest first,
+// so that repeated simplification always terminates. Integers of the pool
+// that are smaller in absolute value are tried after zero, one and half.
+func simplerValues(val reflect.Value, pool []*big.Int) []reflect.Value {
+	T := val.Type()
+	var candidates []reflect.Value
+
+	isInteger := T.Kind() >= reflect.Int8 && T.Kind() <= reflect.Int64 ||
+		T.Kind() >= reflect.Uint8 && T.Kind() <= reflect.Uint64
+	if T == BigIntType || isInteger {
+		var current *big.Int
+		switch {
+		case T == BigIntType:
+			current = val.Interface().(*big.Int)
+		case T.Kind() <= reflect.Int64:
+			current = big.NewInt(val.Int())
+		default:
+			current = new(big.Int).SetUint64(val.Uint())
+		}
+		// Quo truncates towards zero, also for negative numbers
+		integers := []*big.Int{big.NewInt(0), big.NewInt(1), new(big.Int).Quo(current, big.NewInt(2))}
+		fromPool := 0
+		for _, x := range pool {
+			if fromPool == maxPoolCandidates || x.CmpAbs(current) >= 0 {
+				break
+			}
+			integers = append(integers, x)
+			fromPool++
+		}
+		tried := make(map[string]bool)
+		for _, x := range integers {
+			if x.CmpAbs(current) >= 0 || tried[x.String()] {
+				continue
+			}
+			tried[x.String()] = true
+			if candidate, ok := integerValue(x, T); ok {
+				candidates = append(candidates, candidate)
+			}
+		}
+		return candidates
+	}
+
+	switch T {
+	case AddressType:
+		// zero address and accounts that come before the value in accounts file
+		address := val.Interface().(common.Address)
+		if address == (common.Address{}) {
+			return candidates
+		}
+		candidates = append(candidates, reflect.ValueOf(common.Address{}))
+		for _, account := range accounts {
+			if account.Address == address {
+				break
+			}
+			candidates = append(candidates, reflect.ValueOf(account.Address))
+		}
+		return candidates
+	}
+
+	switch T.Kind() {
+	case reflect.Slice:
+		if val.Len() > 0 {
+			candidates = append(candidates, reflect.MakeSlice(T, 0, 0), val.Slice(0, val.Len()/2))
+		}
+	default:
+		// bools, strings and fixed size arrays are only simplified to zero value
+		zero := reflect.Zero(T)
+		if !reflect.DeepEqual(zero.Interface(), val.Interface()) {
+			candidates = append(candidates, zero)
+		}
+	}
+	return candidates
+}
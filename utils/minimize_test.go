@@ -0,0 +1,77 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+// returns predicate that reproduces the violation if methods of required
+// transactions are called in order, as if the last one triggered it
+func requireMethods(required []string) func(txs []*LastTxInput) ([]*LastTxInput, bool) {
+	return func(txs []*LastTxInput) ([]*LastTxInput, bool) {
+		idx := 0
+		for i, input := range txs {
+			if input.Method == required[idx] {
+				idx++
+			}
+			if idx == len(required) {
+				return txs[:i+1], true
+			}
+		}
+		return nil, false
+	}
+}
+
+func TestRemoveTransactions(t *testing.T) {
+	tests := []struct {
+		name     string
+		methods  []string
+		required []string
+		runs     int
+		want     []string
+	}{
+		{"single transaction", []string{"x", "y", "b", "z"}, []string{"b"}, 0, []string{"b"}},
+		{"ordered pair", []string{"x", "a", "y", "y", "b", "z"}, []string{"a", "b"}, 0, []string{"a", "b"}},
+		{"repeated call", []string{"a", "x", "a", "y", "a"}, []string{"a", "a"}, 0, []string{"a", "a"}},
+		{"doesn't reproduce", []string{"x", "y"}, []string{"c"}, 0, []string{"x", "y"}},
+		{"exhausted", []string{"x", "a", "b"}, []string{"b"}, maxShrinkRuns, []string{"x", "a", "b"}},
+	}
+	for _, test := range tests {
+		var txs []*LastTxInput
+		for _, method := range test.methods {
+			txs = append(txs, &LastTxInput{Contract: "A", Method: method})
+		}
+		s := &shrinker{runs: test.runs, replay: requireMethods(test.required)}
+		var got []string
+		for _, input := range s.removeTransactions(txs) {
+			got = append(got, input.Method)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+		if s.runs > maxShrinkRuns {
+			t.Errorf("%v: %v runs exceed the limit", test.name, s.runs)
+		}
+	}
+}
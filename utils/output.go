@@ -0,0 +1,206 @@
+/***
+*
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// layout of the output directory of a campaign
+const (
+	// sequences of transactions triggering violations
+	findingsDir = "findings"
+	// traces of violating transactions
+	tracesDir = "traces"
+	// sequences of transactions that increased coverage
+	corpusDir    = "corpus"
+	coverageDir  = "coverage"
+	manifestFile = "manifest.json"
+)
+
+// creates directory of campaign (named by its start time and seed) with
+// its subdirectories in outDir, returns its path
+func NewCampaignDir(outDir string, start time.Time, seed int64) string {
+	dir := filepath.Join(outDir, fmt.Sprintf("%v_%v", start.Format("20060102-150405"), seed))
+	for _, sub := range []string{findingsDir, tracesDir, corpusDir, coverageDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			panic(fmt.Errorf("Error creating output directory: %v\n", err))
+		}
+	}
+	return dir
+}
+
+// code executed through proxy is covered in its implementation
+func (b *Backend) CodeAddress(address common.Address) common.Address {
+	if implementation, found := b.Proxies[address]; found {
+		return implementation
+	}
+	return address
+}
+
+// saves trace of last transaction, the sequence of transactions that
+// triggered the violation and optionally its minimized version. Files are
+// named by the key of finding and written only by the worker that found the
+// violation first, other workers only count its occurrences.
+func (b *Backend) saveFinding(finding *Finding, argPool *argpool.ArgPool, minimize bool) *SavedFinding {
+	finding.Source = b.SourceLocation(finding.Location, finding.Contract)
+	name := finding.Key()
+	saved := &SavedFinding{
+		Finding:      finding,
+		TraceFile:    filepath.Join(b.OutDir, tracesDir, name+".json"),
+		SequenceFile: filepath.Join(b.OutDir, findingsDir, name+".json"),
+		Occurrences:  1,
+	}
+	if minimize {
+		saved.MinimizedFile = filepath.Join(b.OutDir, findingsDir, name+"_minimized.json")
+	}
+	b.Findings = append(b.Findings, saved)
+	if b.Exchange != nil && !b.Exchange.ClaimFinding(name) {
+		log.Debug(fmt.Sprintf("%v found in %v.%v%v, already saved by another worker", finding.Kind,
+			finding.Contract, finding.Method, finding.SourceSuffix(),
+		))
+		return saved
+	}
+
+	SaveJson(saved.TraceFile, b)
+	SaveFinding(saved.SequenceFile, finding)
+	if minimize {
+		SaveFinding(saved.MinimizedFile, MinimizeFinding(b, argPool, finding))
+	}
+	log.Info(fmt.Sprintf("%v found in %v.%v%v, saved to %v", finding.Kind,
+		finding.Contract, finding.Method, finding.SourceSuffix(), saved.SequenceFile,
+	))
+	return saved
+}
+
+// saves corpus entries as sequences (same format as findings, without kind)
+// so that they can be replayed, returns paths of saved files
+func SaveCorpus(dir string, corpus *Corpus) []string {
+	files := make([]string, 0, corpus.Size())
+	for i, entry := range corpus.Entries {
+		filename := filepath.Join(dir, corpusDir, fmt.Sprintf("entry_%v.json", i))
+		SaveFinding(filename, &Finding{Transactions: entry})
+		files = append(files, filename)
+	}
+	return files
+}
+
+// coverage of contract with covered instructions and JUMPI outcomes
+type contractCoverage struct {
+	*ContractReport
+	CoveredPcs      []uint64 `json:"coveredPcs"`
+	CoveredBranches []Branch `json:"coveredBranches"`
+}
+
+// saves covered instructions and branches of contracts (union over their
+// instances), if contracts have source maps also coverage of source lines is
+// saved (LCOV and HTML), returns paths of saved files
+func SaveCoverage(dir string, backend *Backend, contracts []*ContractReport) []string {
+	coverage := make([]*contractCoverage, 0, len(contracts))
+	sources := make(sourceCoverage)
+	for _, contract := range contracts {
+		pcs := make(map[uint64]bool)
+		branches := make(map[Branch]bool)
+		for _, address := range contract.Instances {
+			for pc := range backend.OpcodeIndices[backend.CodeAddress(address)] {
+				pcs[pc] = true
+			}
+			for branch := range backend.BranchIndices[backend.CodeAddress(address)] {
+				branches[branch] = true
+			}
+		}
+		covered := &contractCoverage{
+			ContractReport:  contract,
+			CoveredPcs:      make([]uint64, 0, len(pcs)),
+			CoveredBranches: make([]Branch, 0, len(branches)),
+		}
+		for pc := range pcs {
+			covered.CoveredPcs = append(covered.CoveredPcs, pc)
+		}
+		sort.Slice(covered.CoveredPcs, func(i, j int) bool {
+			return covered.CoveredPcs[i] < covered.CoveredPcs[j]
+		})
+		for branch := range branches {
+			covered.CoveredBranches = append(covered.CoveredBranches, branch)
+		}
+		sort.Slice(covered.CoveredBranches, func(i, j int) bool {
+			a, b := covered.CoveredBranches[i], covered.CoveredBranches[j]
+			return a.Pc < b.Pc || a.Pc == b.Pc && !a.Taken && b.Taken
+		})
+		coverage = append(coverage, covered)
+		sources.add(backend.Metadata, contract.Name, pcs, branches)
+	}
+	filename := filepath.Join(dir, coverageDir, "coverage.json")
+	writeJSONFile(filename, coverage)
+	return append([]string{filename}, sources.save(dir)...)
+}
+
+// index of the files written to the campaign directory
+type Manifest struct {
+	Config       CampaignConfig   `json:"config"`
+	Started      string           `json:"started"`
+	Duration     float64          `json:"duration"`
+	Transactions int              `json:"transactions"`
+	Findings     []*FindingReport `json:"findings"`
+	Corpus       []string         `json:"corpus"`
+	Coverage     []string         `json:"coverage"`
+}
+
+func WriteManifest(dir string, started time.Time, report *Report, corpus []string,
+	coverage []string) {
+	writeJSONFile(filepath.Join(dir, manifestFile), &Manifest{
+		Config:       report.Config,
+		Started:      started.Format(time.RFC3339),
+		Duration:     report.Duration,
+		Transactions: report.Transactions,
+		Findings:     report.Findings,
+		Corpus:       corpus,
+		Coverage:     coverage,
+	})
+}
+
+func writeJSONFile(filename string, v interface{}) {
+	jsonOut, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Error(fmt.Sprintf("Error marshalling %v: %v", filename, err))
+		return
+	}
+	writeFile(filename, jsonOut)
+}
+
+func writeFile(filename string, data []byte) {
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		log.Error(fmt.Sprintf("Error writing %v: %v", filename, err))
+		return
+	}
+	log.Debug(fmt.Sprintf("Saved %v", filename))
+}
@@ -30,7 +30,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 )
 
@@ -39,6 +38,8 @@ type Options struct {
 	CheckDeployedContract bool
 	UpdateArgPool         bool
 	ExtractTimestamps     bool
+	// add contracts created by transactions to fuzz targets
+	DiscoverContracts bool
 }
 
 var operators = map[vm.OpCode]func(a, b *big.Int) *big.Int{
@@ -70,15 +71,6 @@ var (
 	latestTime   = uint64(1735689600) // 2025.01.01
 )
 
-func getDeployedContractAddress(tx *types.Transaction) common.Address {
-	from, err := types.Sender(types.HomesteadSigner{}, tx)
-	if err != nil {
-		panic(err)
-	}
-	// address of deployed contract
-	return crypto.CreateAddress(from, tx.Nonce())
-}
-
 type callStack struct {
 	stack []*common.Address
 }
@@ -95,6 +87,74 @@ func (c *callStack) Pop() {
 	c.stack = c.stack[:len(c.stack)-1]
 }
 
+// init code of contract being created is executing, its address is not
+// known until CREATE returns
+func (c *callStack) InCreation() bool {
+	top := c.Top()
+	return top == nil || *top == (common.Address{})
+}
+
+// returns location of opcode in currently executing contract
+func (c *callStack) Location(pc uint64) *Location {
+	if c.InCreation() {
+		return nil
+	}
+	top := c.Top()
+	return &Location{Address: *top, Pc: pc}
+}
+
+var cmpOperators = map[vm.OpCode]bool{
+	vm.EQ:  true,
+	vm.LT:  true,
+	vm.GT:  true,
+	vm.SLT: true,
+	vm.SGT: true,
+}
+
+// adds operands of comparison (or SUB followed by ISZERO) to the arg pool,
+// for LT, GT, SLT, SGT also the neighbours of operands are added
+func (b *Backend) extractCmpOperands(structLogs []vm.StructLog, idx int, argPool *argpool.ArgPool) {
+	op := structLogs[idx].Op
+	isSubCmp := op == vm.SUB && idx < len(structLogs)-1 && structLogs[idx+1].Op == vm.ISZERO
+	if !cmpOperators[op] && !isSubCmp {
+		return
+	}
+	st := structLogs[idx].Stack
+	method := methodKey(b.LastTxIn.Contract, b.LastTxIn.Method)
+	for _, operand := range st[len(st)-2:] {
+		argPool.AddCmpValue(method, operand)
+		if op != vm.EQ && !isSubCmp {
+			argPool.AddCmpValue(method, new(big.Int).Add(operand, big.NewInt(1)))
+			if operand.Sign() > 0 {
+				argPool.AddCmpValue(method, new(big.Int).Sub(operand, big.NewInt(1)))
+			}
+		}
+	}
+}
+
+// records edge of jump instruction and outcome of JUMPI
+func (b *Backend) updateBranchCoverage(address common.Address, structLog vm.StructLog, dest uint64) {
+	if b.EdgeIndices[address] == nil {
+		b.EdgeIndices[address] = make(map[Edge]bool)
+		b.BranchIndices[address] = make(map[Branch]bool)
+	}
+	edge := Edge{From: structLog.Pc, To: dest}
+	if !b.EdgeIndices[address][edge] {
+		b.EdgeIndices[address][edge] = true
+		b.CoveredEdges++
+	}
+	if structLog.Op != vm.JUMPI {
+		return
+	}
+	// condition is second item from the top of the stack
+	st := structLog.Stack
+	branch := Branch{Pc: structLog.Pc, Taken: st[len(st)-2].Sign() != 0}
+	if !b.BranchIndices[address][branch] {
+		b.BranchIndices[address][branch] = true
+		b.CoveredBranches++
+	}
+}
+
 func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 	argPool *argpool.ArgPool, options *Options) {
 
@@ -103,6 +163,7 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 	// Update coverage if transaction is sent to some contract
 	updateCoverage = updateCoverage && (tx.To() != nil)
 	updateArgPool := (argPool != nil) && (options != nil) && options.UpdateArgPool
+	discoverContracts := (options != nil) && options.DiscoverContracts
 
 	b.LastTxRes.Output = b.LastTxRes.StructLogger.Output()
 	b.LastTxRes.Receipt = receipt
@@ -111,9 +172,7 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 		UpdatePool(b.LastTxIn, argPool, b.LastTxRes.Output)
 	}
 
-	b.LastTxRes.AssertionAtDepth = -1
-	b.LastTxRes.RevertAtDepth = -1
-	b.LastTxRes.Overflow = ""
+	selfdestructDepth := 0
 	// there's no INVALID defined in opcodes.go in go-ethereum
 	assertOp := vm.OpCode(0xfe)
 	structLogs := b.LastTxRes.StructLogger.StructLogs()
@@ -122,7 +181,59 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 	// which contract
 	callSt := callStack{}
 	callSt.Push(tx.To())
+	reentrancy := newReentrancyTracker(b, tx.To())
+	ether := newEtherTracker(b)
+	access := newAccessTracker(b)
+	// addresses of contracts created by the transaction
+	var created []common.Address
+	// address is computed from the sender recovered with the signer of the
+	// chain, raw transactions may be signed with chain id (EIP-155)
+	if tx.To() == nil && receipt.Status == types.ReceiptStatusSuccessful {
+		created = append(created, receipt.ContractAddress)
+	}
 	for idx, structLog := range structLogs {
+		if idx > 0 {
+			// contract making call to another contract
+			if structLog.Depth > structLogs[idx-1].Depth {
+				prevOp := structLogs[idx-1].Op
+				if prevOp == vm.CREATE || prevOp == vm.CREATE2 {
+					reentrancy.Enter(prevOp, &callSt, common.Address{}, structLog.Depth)
+					callSt.Push(&common.Address{})
+				} else {
+					prevStack := structLogs[idx-1].Stack
+					// address of callee is second to last in stack of previous structlog
+					callee := common.BigToAddress(prevStack[len(prevStack)-2])
+					reentrancy.Enter(prevOp, &callSt, callee, structLog.Depth)
+					callSt.Push(&callee)
+				}
+			}
+			// return from call
+			if structLog.Depth < structLogs[idx-1].Depth {
+				creation := callSt.InCreation()
+				callSt.Pop()
+				reentrancy.Return(structLog)
+				// SELFDESTRUCT is undone if one of the calls it is nested in
+				// fails, result of the call is on top of the stack
+				st := structLog.Stack
+				if selfdestructDepth > structLog.Depth && (len(st) == 0 || st[len(st)-1].Sign() == 0) {
+					b.LastTxRes.SelfdestructAt = nil
+					selfdestructDepth = 0
+				}
+				// address of created contract (zero if creation failed) is
+				// on top of the stack after CREATE returns
+				if creation && len(structLog.Stack) > 0 {
+					address := common.BigToAddress(structLog.Stack[len(structLog.Stack)-1])
+					if address != (common.Address{}) {
+						created = append(created, address)
+					}
+				}
+			}
+		}
+
+		ether.Step(structLog)
+		if (structLog.Op == vm.CALL || structLog.Op == vm.SELFDESTRUCT) && !callSt.InCreation() {
+			ether.Send(structLog, callSt.Location(structLog.Pc))
+		}
 
 		if options.ExtractTimestamps {
 			for _, val := range structLog.Stack {
@@ -137,6 +248,11 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 			}
 		}
 
+		// feed operands of comparisons to the arg pool of called method
+		if updateArgPool && b.LastTxIn != nil {
+			b.extractCmpOperands(structLogs, idx, argPool)
+		}
+
 		// check overflows
 		if b.LastTxRes.Overflow == "" {
 			if fn, found := operators[structLog.Op]; found {
@@ -155,6 +271,7 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 						b.LastTxRes.Overflow = fmt.Sprintf("(%v %v %v=%v), expected:%v",
 							operandA, structLog.Op, operandB, result, expected,
 						)
+						b.LastTxRes.OverflowAt = callSt.Location(structLog.Pc)
 					}
 				}
 			}
@@ -164,6 +281,7 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 		if structLog.Op == assertOp {
 			if b.LastTxRes.AssertionAtDepth == -1 || b.LastTxRes.AssertionAtDepth > structLog.Depth {
 				b.LastTxRes.AssertionAtDepth = structLog.Depth
+				b.LastTxRes.AssertionAt = callSt.Location(structLog.Pc)
 			}
 		}
 
@@ -171,54 +289,59 @@ func (b *Backend) processLogs(receipt *types.Receipt, tx *types.Transaction,
 		if structLog.Op == vm.REVERT {
 			if b.LastTxRes.RevertAtDepth == -1 || b.LastTxRes.RevertAtDepth > structLog.Depth {
 				b.LastTxRes.RevertAtDepth = structLog.Depth
+				b.LastTxRes.RevertAt = callSt.Location(structLog.Pc)
 			}
 		}
 
-		// update coverage for initially called contract only
-		if updateCoverage {
-			if idx > 0 {
-				// contract making call to another contract
-				if structLog.Depth > structLogs[idx-1].Depth {
-					prevStack := structLogs[idx-1].Stack
-					// address of callee is second to last in stack of previous structlog
-					callee := common.BigToAddress(prevStack[len(prevStack)-2])
-					callSt.Push(&callee)
-				}
-				// return from call
-				if structLog.Depth < structLogs[idx-1].Depth {
-					callSt.Pop()
-				}
-			}
+		if structLog.Op == vm.SELFDESTRUCT && b.LastTxRes.SelfdestructAt == nil && !callSt.InCreation() {
+			b.LastTxRes.SelfdestructAt = callSt.Location(structLog.Pc)
+			selfdestructDepth = structLog.Depth
+		}
 
+		if structLog.Op == vm.SSTORE && !callSt.InCreation() {
+			reentrancy.Store(structLog, callSt.Location(structLog.Pc))
+		}
+		if !callSt.InCreation() {
+			access.Step(structLogs, idx, reentrancy.Context(), callSt.Location(structLog.Pc))
+		}
+
+		// update coverage for initially called contract only, code of
+		// attackers is not covered
+		if updateCoverage && !callSt.InCreation() && !b.isAttacker(*callSt.Top()) {
 			top := *callSt.Top()
 			if b.OpcodeIndices[top] == nil {
 				b.OpcodeIndices[top] = make(map[uint64]bool)
 			}
-			b.OpcodeIndices[top][structLog.Pc] = true
-		}
-
-		// contract deployment is detected at RETURN opcode:
-		// swarm hash is searched in memory
-		if !checkDeployedContract || (structLog.Op != vm.RETURN) {
-			continue
-		}
-		// check if contract was deployed
-		hash, found := GetSwarmHash(fmt.Sprintf("%x", structLog.Memory))
-		if !found {
-			continue
+			if !b.OpcodeIndices[top][structLog.Pc] {
+				b.OpcodeIndices[top][structLog.Pc] = true
+				b.CoveredOpcodes++
+			}
+			// jump destination is the pc of next log in the same call
+			isJump := structLog.Op == vm.JUMP || structLog.Op == vm.JUMPI
+			if isJump && idx < len(structLogs)-1 && structLogs[idx+1].Depth == structLog.Depth {
+				b.updateBranchCoverage(top, structLog, structLogs[idx+1].Pc)
+			}
 		}
-		name := GetContractNameByHash(hash, b.Metadata)
-		address := getDeployedContractAddress(tx)
-		if b.DeployedContracts[name] == nil {
-			b.DeployedContracts[name] = &Contract{
-				Addresses: make([]common.Address, 0),
-				Methods:   make([]string, 0),
+	}
+	b.LastTxRes.Reentrancy, b.LastTxRes.ReentrancyAt = reentrancy.Description, reentrancy.Location
+	b.LastTxRes.EtherReceived = ether.Received
+	b.LastTxRes.EtherSent = ether.Sent
+	if tx.To() != nil {
+		b.LastTxRes.AccessControl, b.LastTxRes.AccessControlAt = access.Check(receipt)
+	}
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		for _, address := range created {
+			if b.Created == nil {
+				b.Created = make(map[common.Address]bool)
 			}
+			b.Created[address] = true
+		}
+	}
+	// contracts are registered after the transaction was applied, contracts
+	// created in reverted calls don't have code in the state
+	if checkDeployedContract || discoverContracts {
+		for _, address := range created {
+			b.addCreatedContract(address, discoverContracts)
 		}
-		// argPool.AddAddress(address)
-		b.DeployedContracts[name].Addresses = append(b.DeployedContracts[name].Addresses, address)
-		log.Trace(fmt.Sprintf("Deployed contract: %v with address: %x", name,
-			address,
-		))
 	}
 }
@@ -0,0 +1,337 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// options of the campaign, enough to reproduce it
+type CampaignConfig struct {
+	Metadata      string `json:"metadata"`
+	Contract      string `json:"contract,omitempty"`
+	Limit         int    `json:"limit"`
+	Optimizations int    `json:"optimizations"`
+	Workers       int    `json:"workers"`
+	Seed          int64  `json:"seed"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+type Coverage struct {
+	Covered int `json:"covered"`
+	Total   int `json:"total"`
+}
+
+type ContractReport struct {
+	Name      string           `json:"name"`
+	Source    string           `json:"source,omitempty"`
+	Instances []common.Address `json:"instances"`
+	// coverage of instructions and of JUMPI outcomes (taken/not taken)
+	Instructions Coverage `json:"instructions"`
+	Branches     Coverage `json:"branches"`
+}
+
+type FindingReport struct {
+	Kind        string          `json:"kind"`
+	Contract    string          `json:"contract"`
+	Method      string          `json:"method"`
+	Description string          `json:"description,omitempty"`
+	Address     *common.Address `json:"address,omitempty"`
+	Pc          *uint64         `json:"pc,omitempty"`
+	Source      string          `json:"source,omitempty"`
+	// location of the violating opcode in sources
+	SourceLocation *SourceLocation `json:"sourceLocation,omitempty"`
+	// number of transactions of the sequence leading to the violation
+	Transactions  int    `json:"transactions"`
+	TraceFile     string `json:"traceFile"`
+	SequenceFile  string `json:"sequenceFile"`
+	MinimizedFile string `json:"minimizedFile,omitempty"`
+	Occurrences   int    `json:"occurrences"`
+}
+
+// Machine-readable results of a campaign, written as JSON or SARIF
+type Report struct {
+	Tool    string         `json:"tool"`
+	Version string         `json:"version"`
+	Config  CampaignConfig `json:"config"`
+	// duration of fuzzing in seconds
+	Duration     float64           `json:"duration"`
+	Transactions int               `json:"transactions"`
+	Rate         float64           `json:"rate"`
+	Contracts    []*ContractReport `json:"contracts"`
+	Findings     []*FindingReport  `json:"findings"`
+	// contracts that accepted ether which was never observed to leave them
+	LockedEther []*LockedEther `json:"lockedEther"`
+}
+
+// creates report with findings recorded by backend, coverage of contracts
+// is added by the caller
+func NewReport(version string, config CampaignConfig, backend *Backend,
+	duration float64) *Report {
+	report := &Report{
+		Tool:         "ChainFuzz",
+		Version:      version,
+		Config:       config,
+		Duration:     duration,
+		Transactions: backend.TxCount,
+		Contracts:    make([]*ContractReport, 0),
+		Findings:     make([]*FindingReport, 0),
+		LockedEther:  backend.GetLockedEther(),
+	}
+	if duration > 0 {
+		report.Rate = float64(backend.TxCount) / duration
+	}
+	for _, saved := range backend.Findings {
+		finding := &FindingReport{
+			Kind:           saved.Kind,
+			Contract:       saved.Contract,
+			Method:         saved.Method,
+			Description:    saved.Description,
+			Source:         GetSourcePath(backend.Metadata, saved.Contract),
+			SourceLocation: saved.Source,
+			Transactions:   len(saved.Transactions),
+			TraceFile:      saved.TraceFile,
+			SequenceFile:   saved.SequenceFile,
+			MinimizedFile:  saved.MinimizedFile,
+			Occurrences:    saved.Occurrences,
+		}
+		if saved.Location != nil {
+			address, pc := saved.Location.Address, saved.Location.Pc
+			finding.Address = &address
+			finding.Pc = &pc
+		}
+		report.Findings = append(report.Findings, finding)
+	}
+	return report
+}
+
+func WriteReport(filename string, report *Report) {
+	writeJSONFile(filename, report)
+	log.Info(fmt.Sprintf("Report was written to %v", filename))
+}
+
+// SARIF 2.1.0 log, only the properties used by the report
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID     string                 `json:"ruleId"`
+	Level      string                 `json:"level"`
+	Message    sarifMessage           `json:"message"`
+	Locations  []sarifLocation        `json:"locations"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int           `json:"startLine"`
+	StartColumn int           `json:"startColumn"`
+	EndLine     int           `json:"endLine"`
+	EndColumn   int           `json:"endColumn"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// identifier of kind of violation, e.g. "Property violation" -> "property-violation"
+func kindID(kind string) string {
+	return strings.Replace(strings.ToLower(kind), " ", "-", -1)
+}
+
+// overflows may be intended (e.g. in hash computations), other violations
+// are errors
+func sarifLevel(kind string) string {
+	if kind == OverflowViolation {
+		return "warning"
+	}
+	return "error"
+}
+
+func newSarifResult(finding *FindingReport) sarifResult {
+	method := finding.Method
+	if method == "" {
+		method = "fallback"
+	}
+	text := fmt.Sprintf("%v in %v.%v", finding.Kind, finding.Contract, method)
+	if finding.Description != "" {
+		text = fmt.Sprintf("%v: %v", text, finding.Description)
+	}
+	location := sarifLocation{
+		LogicalLocations: []sarifLogicalLocation{{
+			FullyQualifiedName: fmt.Sprintf("%v.%v", finding.Contract, method),
+			Kind:               "function",
+		}},
+	}
+	// violating opcode may be in another file than the contract (e.g. in an
+	// inherited contract)
+	if source := finding.SourceLocation; source != nil {
+		location.PhysicalLocation = &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: source.File},
+			Region: &sarifRegion{
+				StartLine:   source.Line,
+				StartColumn: source.Column,
+				EndLine:     source.EndLine,
+				EndColumn:   source.EndColumn,
+				Snippet:     &sarifMessage{Text: source.Snippet},
+			},
+		}
+	} else if finding.Source != "" {
+		location.PhysicalLocation = &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: finding.Source},
+		}
+	}
+	properties := map[string]interface{}{
+		"transactions": finding.Transactions,
+		"occurrences":  finding.Occurrences,
+		"traceFile":    finding.TraceFile,
+		"sequenceFile": finding.SequenceFile,
+	}
+	if finding.MinimizedFile != "" {
+		properties["minimizedFile"] = finding.MinimizedFile
+	}
+	if finding.Address != nil {
+		properties["address"] = finding.Address.Hex()
+		properties["pc"] = *finding.Pc
+	}
+	return sarifResult{
+		RuleID:     kindID(finding.Kind),
+		Level:      sarifLevel(finding.Kind),
+		Message:    sarifMessage{Text: text},
+		Locations:  []sarifLocation{location},
+		Properties: properties,
+	}
+}
+
+// locked ether is not caused by a transaction, it is reported as warning
+// without sequence
+func newLockedEtherSarifResult(locked *LockedEther) sarifResult {
+	location := sarifLocation{
+		LogicalLocations: []sarifLogicalLocation{{
+			FullyQualifiedName: locked.Contract,
+			Kind:               "type",
+		}},
+	}
+	if locked.Source != "" {
+		location.PhysicalLocation = &sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: locked.Source},
+		}
+	}
+	return sarifResult{
+		RuleID: kindID(LockedEtherKind),
+		Level:  "warning",
+		Message: sarifMessage{Text: fmt.Sprintf("%v in %v: accepted ether was never observed to leave (%v)",
+			LockedEtherKind, locked.Contract, locked,
+		)},
+		Locations:  []sarifLocation{location},
+		Properties: map[string]interface{}{"instances": locked.Instances, "payable": locked.Payable},
+	}
+}
+
+// writes findings of report in SARIF format, so that they can be shown by
+// code review tools
+func WriteSARIF(filename string, report *Report) {
+	kinds := make(map[string]bool)
+	results := make([]sarifResult, 0, len(report.Findings))
+	for _, finding := range report.Findings {
+		kinds[finding.Kind] = true
+		results = append(results, newSarifResult(finding))
+	}
+	for _, locked := range report.LockedEther {
+		kinds[LockedEtherKind] = true
+		results = append(results, newLockedEtherSarifResult(locked))
+	}
+	rules := make([]sarifRule, 0, len(kinds))
+	for kind := range kinds {
+		rules = append(rules, sarifRule{
+			ID:               kindID(kind),
+			Name:             kind,
+			ShortDescription: sarifMessage{Text: kind},
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	writeJSONFile(filename, sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           report.Tool,
+				Version:        report.Version,
+				InformationURI: "https://github.com/ChainSecurity/ChainFuzz",
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	})
+	log.Info(fmt.Sprintf("SARIF report was written to %v", filename))
+}
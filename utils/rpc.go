@@ -0,0 +1,490 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var errExecutionReverted = errors.New("execution reverted")
+
+// arguments of eth_sendTransaction, eth_call and eth_estimateGas
+type RPCTxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      *hexutil.Uint64 `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    *hexutil.Uint64 `json:"nonce"`
+	Data     *hexutil.Bytes  `json:"data"`
+	Input    *hexutil.Bytes  `json:"input"`
+}
+
+func (args *RPCTxArgs) data() []byte {
+	if args.Input != nil {
+		return *args.Input
+	}
+	if args.Data != nil {
+		return *args.Data
+	}
+	return nil
+}
+
+func (args *RPCTxArgs) value() *big.Int {
+	if args.Value != nil {
+		return args.Value.ToInt()
+	}
+	return new(big.Int)
+}
+
+func (args *RPCTxArgs) gasPrice() *big.Int {
+	if args.GasPrice != nil {
+		return args.GasPrice.ToInt()
+	}
+	return new(big.Int)
+}
+
+// transaction applied by the recorder, every transaction is mined in its own block
+type recordedTx struct {
+	tx      *types.Transaction
+	from    common.Address
+	receipt *types.Receipt
+	block   uint64
+}
+
+// applies transactions received over JSON-RPC to the backend and writes
+// them to the transactions file of the project (read by ReadTransactions)
+type recorder struct {
+	mu       sync.Mutex
+	backend  *Backend
+	txFile   *os.File
+	txs      []*recordedTx
+	txByHash map[common.Hash]*recordedTx
+}
+
+// hash of the block with given number
+func blockHash(number uint64) common.Hash {
+	return crypto.Keccak256Hash(new(big.Int).SetUint64(number).Bytes())
+}
+
+// header used for all transactions, the same as during the replay of
+// deployment in NewBackend (block number and timestamp don't change)
+func (r *recorder) header() *types.Header {
+	header := types.CopyHeader(GetDefaultHeader(r.backend))
+	header.GasUsed = 0
+	return header
+}
+
+func (r *recorder) blockNumber() uint64 {
+	return uint64(len(r.txs))
+}
+
+func (r *recorder) apply(tx *types.Transaction, from common.Address) (common.Hash, error) {
+	if _, found := addressToKey[from]; !found {
+		return common.Hash{}, fmt.Errorf("sender %v is not in accounts file", from.Hex())
+	}
+	number := r.blockNumber() + 1
+	r.backend.StateDB.Prepare(tx.Hash(), blockHash(number), 0)
+	if err, _ := r.backend.CommitTransaction(tx, nil, &Options{}, r.header()); err != nil {
+		return common.Hash{}, err
+	}
+	receipt := r.backend.LastTxRes.Receipt
+	for _, l := range receipt.Logs {
+		l.BlockNumber = number
+		l.BlockHash = blockHash(number)
+	}
+	recorded := &recordedTx{tx: tx, from: from, receipt: receipt, block: number}
+	r.txs = append(r.txs, recorded)
+	r.txByHash[tx.Hash()] = recorded
+
+	line, _ := json.Marshal(newTransactionJSON(tx, from, blockHash(number), int(number)))
+	if _, err := r.txFile.Write(append(line, '\n')); err != nil {
+		panic(fmt.Errorf("error writing transactions file: %+v\n", err))
+	}
+	log.Info(fmt.Sprintf("Recorded transaction %v from: %v to: %v status: %v",
+		tx.Hash().Hex(), from.Hex(), tx.To(), receipt.Status,
+	))
+	if receipt.Status == types.ReceiptStatusFailed {
+		return tx.Hash(), fmt.Errorf("VM Exception while processing transaction %v: revert",
+			tx.Hash().Hex(),
+		)
+	}
+	return tx.Hash(), nil
+}
+
+func (r *recorder) call(args *RPCTxArgs, gas uint64) ([]byte, bool, error) {
+	return r.backend.SimulateCall(args.From, args.To, args.value(), args.data(), gas, nil)
+}
+
+// binary search of the lowest gas limit the transaction succeeds with
+func (r *recorder) estimateGas(args *RPCTxArgs) (uint64, error) {
+	lo, hi := params.TxGas-1, uint64(*maxGasPool)
+	if _, failed, err := r.call(args, hi); err != nil || failed {
+		return 0, fmt.Errorf("gas required exceeds allowance or always failing transaction")
+	}
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if _, failed, err := r.call(args, mid); err != nil || failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+func (r *recorder) receipt(recorded *recordedTx) map[string]interface{} {
+	receipt := recorded.receipt
+	res := map[string]interface{}{
+		"transactionHash":   recorded.tx.Hash(),
+		"transactionIndex":  hexutil.Uint64(0),
+		"blockHash":         blockHash(recorded.block),
+		"blockNumber":       hexutil.Uint64(recorded.block),
+		"from":              recorded.from,
+		"to":                recorded.tx.To(),
+		"cumulativeGasUsed": hexutil.Uint64(receipt.GasUsed),
+		"gasUsed":           hexutil.Uint64(receipt.GasUsed),
+		"contractAddress":   nil,
+		"logs":              receipt.Logs,
+		"logsBloom":         receipt.Bloom,
+		"status":            hexutil.Uint64(receipt.Status),
+	}
+	if receipt.Logs == nil {
+		res["logs"] = []*types.Log{}
+	}
+	if recorded.tx.To() == nil {
+		res["contractAddress"] = receipt.ContractAddress
+	}
+	return res
+}
+
+func (r *recorder) transaction(recorded *recordedTx) map[string]interface{} {
+	tx := recorded.tx
+	v, rs, s := tx.RawSignatureValues()
+	return map[string]interface{}{
+		"hash":             tx.Hash(),
+		"nonce":            hexutil.Uint64(tx.Nonce()),
+		"blockHash":        blockHash(recorded.block),
+		"blockNumber":      hexutil.Uint64(recorded.block),
+		"transactionIndex": hexutil.Uint64(0),
+		"from":             recorded.from,
+		"to":               tx.To(),
+		"value":            (*hexutil.Big)(tx.Value()),
+		"gas":              hexutil.Uint64(tx.Gas()),
+		"gasPrice":         (*hexutil.Big)(tx.GasPrice()),
+		"input":            hexutil.Bytes(tx.Data()),
+		"v":                (*hexutil.Big)(v),
+		"r":                (*hexutil.Big)(rs),
+		"s":                (*hexutil.Big)(s),
+	}
+}
+
+func (r *recorder) block(number uint64, fullTx bool) map[string]interface{} {
+	header := r.header()
+	parentHash := common.Hash{}
+	if number > 0 {
+		parentHash = blockHash(number - 1)
+	}
+	txs := make([]interface{}, 0)
+	gasUsed := uint64(0)
+	if number > 0 {
+		recorded := r.txs[number-1]
+		gasUsed = recorded.receipt.GasUsed
+		if fullTx {
+			txs = append(txs, r.transaction(recorded))
+		} else {
+			txs = append(txs, recorded.tx.Hash())
+		}
+	}
+	return map[string]interface{}{
+		"number":           hexutil.Uint64(number),
+		"hash":             blockHash(number),
+		"parentHash":       parentHash,
+		"nonce":            types.BlockNonce{},
+		"sha3Uncles":       types.EmptyUncleHash,
+		"logsBloom":        types.Bloom{},
+		"transactionsRoot": types.EmptyRootHash,
+		"stateRoot":        common.Hash{},
+		"receiptsRoot":     types.EmptyRootHash,
+		"miner":            coinBase,
+		"difficulty":       (*hexutil.Big)(header.Difficulty),
+		"totalDifficulty":  (*hexutil.Big)(new(big.Int).SetUint64(number + 1)),
+		"extraData":        hexutil.Bytes{},
+		"size":             hexutil.Uint64(0),
+		"gasLimit":         hexutil.Uint64(*maxGasPool),
+		"gasUsed":          hexutil.Uint64(gasUsed),
+		"timestamp":        (*hexutil.Big)(header.Time),
+		"transactions":     txs,
+		"uncles":           []common.Hash{},
+	}
+}
+
+// eth namespace
+type EthAPI struct {
+	r *recorder
+}
+
+func (api *EthAPI) Accounts() []common.Address {
+	res := make([]common.Address, 0, len(accounts))
+	for _, account := range accounts {
+		res = append(res, account.Address)
+	}
+	return res
+}
+
+func (api *EthAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.r.backend.ChainConfig.ChainID)
+}
+
+func (api *EthAPI) Coinbase() common.Address {
+	return coinBase
+}
+
+func (api *EthAPI) Syncing() bool {
+	return false
+}
+
+func (api *EthAPI) Mining() bool {
+	return false
+}
+
+func (api *EthAPI) GasPrice() *hexutil.Big {
+	return (*hexutil.Big)(new(big.Int))
+}
+
+func (api *EthAPI) BlockNumber() hexutil.Uint64 {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	return hexutil.Uint64(api.r.blockNumber())
+}
+
+// block number argument is ignored, the latest state is always used
+func (api *EthAPI) GetBalance(address common.Address, number *rpc.BlockNumber) *hexutil.Big {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	return (*hexutil.Big)(api.r.backend.StateDB.GetBalance(address))
+}
+
+func (api *EthAPI) GetTransactionCount(address common.Address, number *rpc.BlockNumber) hexutil.Uint64 {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	return hexutil.Uint64(api.r.backend.StateDB.GetNonce(address))
+}
+
+func (api *EthAPI) GetCode(address common.Address, number *rpc.BlockNumber) hexutil.Bytes {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	return api.r.backend.StateDB.GetCode(address)
+}
+
+func (api *EthAPI) GetStorageAt(address common.Address, key string, number *rpc.BlockNumber) hexutil.Bytes {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	value := api.r.backend.StateDB.GetState(address, common.HexToHash(key))
+	return value[:]
+}
+
+func (api *EthAPI) Call(args RPCTxArgs, number *rpc.BlockNumber) (hexutil.Bytes, error) {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	gas := uint64(*maxGasPool)
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	}
+	ret, failed, err := api.r.call(&args, gas)
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		return nil, errExecutionReverted
+	}
+	return ret, nil
+}
+
+func (api *EthAPI) EstimateGas(args RPCTxArgs, number *rpc.BlockNumber) (hexutil.Uint64, error) {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	gas, err := api.r.estimateGas(&args)
+	return hexutil.Uint64(gas), err
+}
+
+// signs transaction with the key of sender from accounts file and applies it
+func (api *EthAPI) SendTransaction(args RPCTxArgs) (common.Hash, error) {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	key, found := addressToKey[args.From]
+	if !found {
+		return common.Hash{}, fmt.Errorf("sender %v is not in accounts file", args.From.Hex())
+	}
+	nonce := api.r.backend.StateDB.GetNonce(args.From)
+	if args.Nonce != nil {
+		nonce = uint64(*args.Nonce)
+	}
+	var gas uint64
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	} else {
+		estimated, err := api.r.estimateGas(&args)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		gas = estimated
+	}
+	var tx *types.Transaction
+	if args.To == nil {
+		tx = types.NewContractCreation(nonce, args.value(), gas, args.gasPrice(), args.data())
+	} else {
+		tx = types.NewTransaction(nonce, *args.To, args.value(), gas, args.gasPrice(), args.data())
+	}
+	signed, err := types.SignTx(tx, types.HomesteadSigner{}, key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return api.r.apply(signed, args.From)
+}
+
+func (api *EthAPI) SendRawTransaction(encoded hexutil.Bytes) (common.Hash, error) {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(encoded, tx); err != nil {
+		return common.Hash{}, err
+	}
+	signer := types.NewEIP155Signer(api.r.backend.ChainConfig.ChainID)
+	from, err := types.Sender(signer, tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return api.r.apply(tx, from)
+}
+
+func (api *EthAPI) GetTransactionReceipt(hash common.Hash) map[string]interface{} {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	if recorded, found := api.r.txByHash[hash]; found {
+		return api.r.receipt(recorded)
+	}
+	return nil
+}
+
+func (api *EthAPI) GetTransactionByHash(hash common.Hash) map[string]interface{} {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	if recorded, found := api.r.txByHash[hash]; found {
+		return api.r.transaction(recorded)
+	}
+	return nil
+}
+
+func (api *EthAPI) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) map[string]interface{} {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	if number < 0 {
+		return api.r.block(api.r.blockNumber(), fullTx)
+	}
+	if uint64(number) > api.r.blockNumber() {
+		return nil
+	}
+	return api.r.block(uint64(number), fullTx)
+}
+
+func (api *EthAPI) GetBlockByHash(hash common.Hash, fullTx bool) map[string]interface{} {
+	api.r.mu.Lock()
+	defer api.r.mu.Unlock()
+	for number := uint64(0); number <= api.r.blockNumber(); number++ {
+		if blockHash(number) == hash {
+			return api.r.block(number, fullTx)
+		}
+	}
+	return nil
+}
+
+// net namespace
+type NetAPI struct {
+	r *recorder
+}
+
+func (api *NetAPI) Version() string {
+	return api.r.backend.ChainConfig.ChainID.String()
+}
+
+func (api *NetAPI) Listening() bool {
+	return true
+}
+
+func (api *NetAPI) PeerCount() hexutil.Uint {
+	return 0
+}
+
+// web3 namespace
+type Web3API struct{}
+
+func (api *Web3API) ClientVersion() string {
+	return "ChainFuzz"
+}
+
+func (api *Web3API) Sha3(input hexutil.Bytes) hexutil.Bytes {
+	return crypto.Keccak256(input)
+}
+
+// Returns JSON-RPC server (eth, net and web3 namespaces) that applies
+// deployment transactions to the backend and records them into transactions
+// file specified in metadata file
+func NewRPCServer(backend *Backend) *rpc.Server {
+	txFile := getTxJSONFile(backend.Metadata)
+	f, err := os.Create(txFile)
+	if err != nil {
+		panic(fmt.Errorf("error creating transactions file: %+v\n", err))
+	}
+	r := &recorder{
+		backend:  backend,
+		txFile:   f,
+		txByHash: make(map[common.Hash]*recordedTx),
+	}
+	server := rpc.NewServer()
+	apis := map[string]interface{}{
+		"eth":  &EthAPI{r},
+		"net":  &NetAPI{r},
+		"web3": &Web3API{},
+	}
+	for namespace, api := range apis {
+		if err := server.RegisterName(namespace, api); err != nil {
+			panic(fmt.Errorf("error registering %v api: %+v\n", namespace, err))
+		}
+	}
+	return server
+}
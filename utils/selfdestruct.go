@@ -0,0 +1,76 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// returns name of deployed contract that has instance at address
+func (b *Backend) deployedContractAt(address common.Address) (string, bool) {
+	for _, contract := range GetDeployedContractNames(b) {
+		if b.hasInstance(contract, address) {
+			return contract, true
+		}
+	}
+	return "", false
+}
+
+// checks if instance exists in the current sequence: it had code in the
+// snapshot or was created since, instances discovered while fuzzing other
+// sequences don't exist after the state was reverted
+func (b *Backend) existsInSequence(address common.Address) bool {
+	return b.Created[address] || (b.snapshot != nil && b.snapshot.GetCodeSize(address) > 0)
+}
+
+// Returns violation if SELFDESTRUCT of a deployed contract was executed (and
+// not reverted) in the last transaction and the transaction was sent by an
+// unprivileged account or through an attacker contract, or if some fuzzed
+// contract that exists in the sequence has no code after it (code of
+// contract may be destroyed by a library it delegates to).
+func (b *Backend) selfdestructViolation() (Violation, bool) {
+	location := b.LastTxRes.SelfdestructAt
+	if location == nil {
+		return Violation{}, false
+	}
+	var reasons []string
+	sender := b.LastTxIn.caller()
+	if contract, found := b.deployedContractAt(location.Address); found && sender != nil && b.isUnprivileged(*sender) {
+		reasons = append(reasons, fmt.Sprintf("%v at %v destroyed by unprivileged %v",
+			contract, location.Address.Hex(), sender.Hex(),
+		))
+	}
+	for _, contract := range b.ContractsList {
+		for _, address := range b.DeployedContracts[contract].Addresses {
+			if b.existsInSequence(address) && b.StateDB.GetCodeSize(address) == 0 {
+				reasons = append(reasons, fmt.Sprintf("%v at %v has no code", contract, address.Hex()))
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		return Violation{}, false
+	}
+	return Violation{SelfdestructViolation, strings.Join(reasons, ", "), location}, true
+}
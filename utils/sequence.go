@@ -0,0 +1,264 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// kinds of violations reported by fuzzer
+const (
+	OverflowViolation     = "Overflow"
+	AssertionViolation    = "AssertionFailure"
+	PropertyViolation     = "Property violation"
+	RevertInFuzzViolation = "Revert in fuzz function"
+	ReentrancyViolation   = "Reentrancy"
+	EtherLeakViolation    = "Ether leak"
+	SelfdestructViolation = "Selfdestruct"
+	AccessViolation       = "Access control"
+)
+
+type Violation struct {
+	Kind        string
+	Description string
+	// opcode that triggered the violation if known
+	Location *Location
+}
+
+// returns violations triggered by the last transaction
+func GetViolations(backend *Backend) []Violation {
+	var violations []Violation
+	res := backend.LastTxRes
+	// transaction was not applied
+	if res.Receipt == nil {
+		return violations
+	}
+	reverted := res.RevertAtDepth == 1
+	// If overflow happens and transaction is not reverted
+	if res.Overflow != "" && !reverted {
+		violations = append(violations, Violation{OverflowViolation, res.Overflow, res.OverflowAt})
+	}
+	if res.AssertionAtDepth != -1 {
+		violations = append(violations, Violation{AssertionViolation, "", res.AssertionAt})
+	}
+	if res.Reentrancy != "" && res.Receipt.Status == types.ReceiptStatusSuccessful {
+		description := res.Reentrancy
+		if backend.LastTxIn.ReentryMethod != "" {
+			description = fmt.Sprintf("%v (re-entered %v)", description, backend.LastTxIn.ReentryMethod)
+		}
+		violations = append(violations, Violation{ReentrancyViolation, description, res.ReentrancyAt})
+	}
+	if res.AccessControl != "" {
+		violations = append(violations, Violation{AccessViolation, res.AccessControl, res.AccessControlAt})
+	}
+	if violation, found := backend.selfdestructViolation(); found && res.Receipt.Status == types.ReceiptStatusSuccessful {
+		violations = append(violations, violation)
+	}
+	if violation, found := backend.etherLeak(); found && res.Receipt.Status == types.ReceiptStatusSuccessful {
+		violations = append(violations, violation)
+	}
+	if strings.Index(backend.LastTxIn.Method, "fuzz_always_true") == 0 {
+		if !reverted && len(res.Output) == 32 && res.Output[31] != 1 {
+			violations = append(violations, Violation{PropertyViolation, "", nil})
+		}
+		if reverted {
+			violations = append(violations, Violation{RevertInFuzzViolation, "", res.RevertAt})
+		}
+	}
+	return violations
+}
+
+// violation together with the sequence of transactions (applied after
+// deployment) that leads to it, last transaction triggers the violation
+type Finding struct {
+	Kind         string         `json:"kind"`
+	Contract     string         `json:"contract"`
+	Method       string         `json:"method"`
+	Description  string         `json:"description"`
+	Location     *Location      `json:"location"`
+	Transactions []*LastTxInput `json:"transactions"`
+
+	// location of the violating opcode in sources
+	Source *SourceLocation `json:"source,omitempty"`
+}
+
+func NewFinding(backend *Backend, violation Violation) *Finding {
+	transactions := make([]*LastTxInput, len(backend.TxSequence))
+	copy(transactions, backend.TxSequence)
+	return &Finding{
+		Kind:         violation.Kind,
+		Contract:     backend.LastTxIn.Contract,
+		Method:       backend.LastTxIn.Method,
+		Description:  violation.Description,
+		Location:     violation.Location,
+		Transactions: transactions,
+	}
+}
+
+func SaveFinding(filename string, finding *Finding) {
+	jsonOut, err := json.MarshalIndent(finding, "", "  ")
+	if err != nil {
+		log.Error(fmt.Sprintf("Error marshalling sequence of transactions: %v", err))
+		return
+	}
+	if err := ioutil.WriteFile(filename, jsonOut, 0644); err != nil {
+		log.Error(fmt.Sprintf("Error writing sequence of transactions: %v", err))
+		return
+	}
+	log.Debug(fmt.Sprintf("Saved sequence of %v transactions to %v.", len(finding.Transactions), filename))
+}
+
+// finding together with files it was saved to
+type SavedFinding struct {
+	*Finding
+	TraceFile     string
+	SequenceFile  string
+	MinimizedFile string
+	// number of times the violation was triggered
+	Occurrences int
+}
+
+// findings with the same key report the same bug: kind, contract, method and
+// pc of the violating opcode (instances of a contract share their bugs)
+func (f *Finding) Key() string {
+	pc := "none"
+	if f.Location != nil {
+		pc = fmt.Sprintf("%v", f.Location.Pc)
+	}
+	method := f.Method
+	if method == "" {
+		method = "fallback"
+	}
+	return fmt.Sprintf("%v_%v_%v_%v", kindID(f.Kind), f.Contract, method, pc)
+}
+
+// returns saved finding reporting the same bug, nil if there is none
+func (b *Backend) getFinding(finding *Finding) *SavedFinding {
+	for _, saved := range b.Findings {
+		if saved.Key() == finding.Key() {
+			return saved
+		}
+	}
+	return nil
+}
+
+// adds finding of another worker, occurrences of the same bug are summed up
+func (b *Backend) mergeFinding(finding *SavedFinding) {
+	if saved := b.getFinding(finding.Finding); saved != nil {
+		saved.Occurrences = saved.Occurrences + finding.Occurrences
+		return
+	}
+	b.Findings = append(b.Findings, finding)
+}
+
+// returns " at file:line:column" of the violating opcode, empty if unknown
+func (f *Finding) SourceSuffix() string {
+	if f.Source == nil {
+		return ""
+	}
+	return fmt.Sprintf(" at %v", f.Source)
+}
+
+// checks if the violation is the same one that was reported in finding
+func (f *Finding) Matches(input *LastTxInput, violation Violation) bool {
+	if f.Kind != violation.Kind || f.Contract != input.Contract || f.Method != input.Method {
+		return false
+	}
+	if f.Location == nil || violation.Location == nil {
+		return f.Location == violation.Location
+	}
+	return *f.Location == *violation.Location
+}
+
+// reads sequence of transactions saved for a finding, arguments are decoded
+// from raw input of transactions so that they can be packed again
+func LoadFinding(filename string, metadata string) (*Finding, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading sequence file %v: %v", filename, err)
+	}
+	finding := &Finding{}
+	if err := json.Unmarshal(content, finding); err != nil {
+		return nil, fmt.Errorf("error unmarshalling sequence file %v: %v", filename, err)
+	}
+	if err := decodeTransactions(finding.Transactions, metadata); err != nil {
+		return nil, err
+	}
+	return finding, nil
+}
+
+// decodes arguments of transactions unmarshalled from JSON from their raw
+// input, so that they can be packed again
+func decodeTransactions(transactions []*LastTxInput, metadata string) error {
+	for i, input := range transactions {
+		evmABI, found := GetABIMap(metadata)[input.Contract]
+		if !found {
+			return fmt.Errorf("transaction %v: contract %v not found", i+1, input.Contract)
+		}
+		if _, found := evmABI.Methods[input.Method]; input.Method != "" && !found {
+			return fmt.Errorf("transaction %v: method %v.%v not found", i+1, input.Contract, input.Method)
+		}
+		methodABI := GetContractMethod(input.Contract, input.Method, metadata)
+		input.Const = methodABI.Const
+		input.OutArgs = methodABI.Outputs
+		// fallback transactions don't have method selector in input
+		payload := []byte(input.Payload)
+		if input.Method != "" {
+			if len(payload) < 4 {
+				return fmt.Errorf("transaction %v: input of %v.%v has no method selector",
+					i+1, input.Contract, input.Method,
+				)
+			}
+			payload = payload[4:]
+		}
+		args, err := methodABI.Inputs.UnpackValues(payload)
+		if err != nil {
+			return fmt.Errorf("transaction %v: error decoding input of %v.%v: %v",
+				i+1, input.Contract, input.Method, err,
+			)
+		}
+		input.Input = &args
+	}
+	return nil
+}
+
+// applies transaction described by input with it's recorded sender, ether
+// amount and block timestamp
+func ReplayTransaction(backend *Backend, argPool *argpool.ArgPool,
+	input *LastTxInput, options *Options) error {
+	header := GetDefaultHeader(backend)
+	if input.Timestamp != nil {
+		header.Time = input.Timestamp
+	}
+	backend.LastTxIn = input
+	tx := BuildTransaction(backend, input)
+	err, _ := backend.CommitTransaction(tx, argPool, options, header)
+	return err
+}
@@ -24,18 +24,22 @@ package utils
 import (
 	"fmt"
 
-	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/log"
 )
 
-var backendSnapshot *state.StateDB
-
 func SnapshotBackend(backend *Backend) {
-	backendSnapshot = backend.StateDB.Copy()
+	backend.snapshot = backend.StateDB.Copy()
+	backend.TxSequence = nil
+	backend.Grants = nil
+	backend.Created = nil
 	log.Trace(fmt.Sprintf("STATE: new snapshot version has been created"))
 }
 
 func RevertBackend(backend *Backend) {
-	*backend.StateDB = *backendSnapshot
+	// copy snapshot, otherwise the following transactions would modify it
+	*backend.StateDB = *backend.snapshot.Copy()
+	backend.TxSequence = nil
+	backend.Grants = nil
+	backend.Created = nil
 	log.Trace(fmt.Sprintf("STATE: state has been reverted"))
 }
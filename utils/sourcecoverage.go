@@ -0,0 +1,245 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	lcovFile         = "lcov.info"
+	coverageHTMLFile = "index.html"
+)
+
+// outcomes of JUMPI instruction
+type branchCoverage struct {
+	Executed bool
+	Taken    bool
+	NotTaken bool
+}
+
+// coverage of lines of source file, a line is instrumented if some
+// instruction starts on it and covered if any of them was executed
+type fileCoverage struct {
+	file  *sourceFile
+	lines map[int]bool
+	// JUMPI instructions starting on each line
+	branches map[int][]*branchCoverage
+}
+
+func (c *fileCoverage) partial(line int) bool {
+	for _, branch := range c.branches[line] {
+		if branch.Executed && (!branch.Taken || !branch.NotTaken) {
+			return true
+		}
+	}
+	return false
+}
+
+// returns number of instrumented and covered lines
+func (c *fileCoverage) lineCount() (int, int) {
+	covered := 0
+	for _, hit := range c.lines {
+		if hit {
+			covered++
+		}
+	}
+	return len(c.lines), covered
+}
+
+// returns number of branch outcomes and covered outcomes
+func (c *fileCoverage) branchCount() (int, int) {
+	total, covered := 0, 0
+	for _, branches := range c.branches {
+		for _, branch := range branches {
+			total += 2
+			if branch.Taken {
+				covered++
+			}
+			if branch.NotTaken {
+				covered++
+			}
+		}
+	}
+	return total, covered
+}
+
+func (c *fileCoverage) sortedLines() []int {
+	lines := make([]int, 0, len(c.lines))
+	for line := range c.lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// coverage of source files over all fuzzed contracts
+type sourceCoverage map[*sourceFile]*fileCoverage
+
+// maps covered instructions and branches of contract to lines of its sources
+func (s sourceCoverage) add(metadata string, contract string, pcs map[uint64]bool,
+	branches map[Branch]bool) {
+	for _, instruction := range getContractSources(metadata, contract) {
+		coverage := s[instruction.File]
+		if coverage == nil {
+			coverage = &fileCoverage{
+				file:     instruction.File,
+				lines:    make(map[int]bool),
+				branches: make(map[int][]*branchCoverage),
+			}
+			s[instruction.File] = coverage
+		}
+		line, _ := instruction.File.Position(instruction.Offset)
+		coverage.lines[line] = coverage.lines[line] || pcs[instruction.Pc]
+		if instruction.Op == "JUMPI" {
+			coverage.branches[line] = append(coverage.branches[line], &branchCoverage{
+				Executed: pcs[instruction.Pc],
+				Taken:    branches[Branch{Pc: instruction.Pc, Taken: true}],
+				NotTaken: branches[Branch{Pc: instruction.Pc, Taken: false}],
+			})
+		}
+	}
+}
+
+func (s sourceCoverage) sortedFiles() []*fileCoverage {
+	res := make([]*fileCoverage, 0, len(s))
+	for _, coverage := range s {
+		res = append(res, coverage)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].file.Path < res[j].file.Path
+	})
+	return res
+}
+
+// writes coverage in LCOV tracefile format, branches are identified by
+// their JUMPI instruction, branch 0 is taken and 1 not taken outcome
+func (s sourceCoverage) writeLCOV(filename string) {
+	var out bytes.Buffer
+	for _, coverage := range s.sortedFiles() {
+		fmt.Fprintf(&out, "TN:\nSF:%v\n", coverage.file.Path)
+		for _, line := range coverage.sortedLines() {
+			for block, branch := range coverage.branches[line] {
+				for idx, hit := range []bool{branch.Taken, branch.NotTaken} {
+					taken := "-"
+					if branch.Executed {
+						taken = fmt.Sprint(boolToInt(hit))
+					}
+					fmt.Fprintf(&out, "BRDA:%v,%v,%v,%v\n", line, block, idx, taken)
+				}
+			}
+		}
+		branchesFound, branchesHit := coverage.branchCount()
+		fmt.Fprintf(&out, "BRF:%v\nBRH:%v\n", branchesFound, branchesHit)
+		for _, line := range coverage.sortedLines() {
+			fmt.Fprintf(&out, "DA:%v,%v\n", line, boolToInt(coverage.lines[line]))
+		}
+		linesFound, linesHit := coverage.lineCount()
+		fmt.Fprintf(&out, "LF:%v\nLH:%v\nend_of_record\n", linesFound, linesHit)
+	}
+	writeFile(filename, out.Bytes())
+}
+
+const coverageHTMLHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Source coverage</title>
+<style>
+body { font-family: sans-serif; }
+table.summary td, table.summary th { padding: 2px 12px; text-align: left; }
+pre { line-height: 1.3; }
+.no { color: #888; display: inline-block; width: 5em; }
+.covered { background: #d7f5d7; }
+.uncovered { background: #f8d4d4; }
+.partial { background: #fbefc4; }
+</style>
+</head>
+<body>
+<h1>Source coverage</h1>
+`
+
+// writes sources with highlighted lines: covered, not covered and lines with
+// a branch of which only one outcome was covered
+func (s sourceCoverage) writeHTML(filename string) {
+	var out bytes.Buffer
+	out.WriteString(coverageHTMLHeader)
+	files := s.sortedFiles()
+	out.WriteString("<table class=\"summary\">\n<tr><th>File</th><th>Lines</th><th>Branches</th></tr>\n")
+	for i, coverage := range files {
+		linesFound, linesHit := coverage.lineCount()
+		branchesFound, branchesHit := coverage.branchCount()
+		fmt.Fprintf(&out, "<tr><td><a href=\"#file-%v\">%v</a></td><td>%v/%v</td><td>%v/%v</td></tr>\n",
+			i, html.EscapeString(coverage.file.Path), linesHit, linesFound,
+			branchesHit, branchesFound,
+		)
+	}
+	out.WriteString("</table>\n")
+	for i, coverage := range files {
+		fmt.Fprintf(&out, "<h2 id=\"file-%v\">%v</h2>\n<pre>", i, html.EscapeString(coverage.file.Path))
+		for idx, text := range coverage.file.Lines() {
+			line := idx + 1
+			class := ""
+			if hit, found := coverage.lines[line]; found {
+				switch {
+				case !hit:
+					class = "uncovered"
+				case coverage.partial(line):
+					class = "partial"
+				default:
+					class = "covered"
+				}
+			}
+			fmt.Fprintf(&out, "<span class=\"%v\"><span class=\"no\">%v</span>%v</span>\n",
+				class, line, html.EscapeString(text),
+			)
+		}
+		out.WriteString("</pre>\n")
+	}
+	out.WriteString("</body>\n</html>\n")
+	writeFile(filename, out.Bytes())
+}
+
+// writes LCOV and HTML reports to coverage directory, returns paths of
+// written files (none if contracts have no source maps)
+func (s sourceCoverage) save(dir string) []string {
+	if len(s) == 0 {
+		return nil
+	}
+	lcov := filepath.Join(dir, coverageDir, lcovFile)
+	report := filepath.Join(dir, coverageDir, coverageHTMLFile)
+	s.writeLCOV(lcov)
+	s.writeHTML(report)
+	return []string{lcov, report}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
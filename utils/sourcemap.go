@@ -0,0 +1,237 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/asm"
+)
+
+// source files of each project: sourceFiles[metadata][compilation, index]
+var sourceFiles map[string]map[sourceKey]*sourceFile
+
+// source files are identified by their index within compilation
+type sourceKey struct {
+	Compilation string
+	ID          int
+}
+
+type sourceFile struct {
+	Path    string
+	Content string
+	// byte offsets at which lines start
+	lineStarts []int
+}
+
+func newSourceFile(path string, content string) *sourceFile {
+	file := &sourceFile{Path: path, Content: content, lineStarts: []int{0}}
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\n' {
+			file.lineStarts = append(file.lineStarts, i+1)
+		}
+	}
+	return file
+}
+
+// returns line and column (both starting at 1) of byte offset
+func (f *sourceFile) Position(offset int) (int, int) {
+	line := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	})
+	return line, offset - f.lineStarts[line-1] + 1
+}
+
+func (f *sourceFile) Lines() []string {
+	return strings.Split(strings.TrimSuffix(f.Content, "\n"), "\n")
+}
+
+// location of instruction in sources, as it's stored in solidity source maps
+type sourceRange struct {
+	Offset int
+	Length int
+	// index of source file, -1 for code generated by compiler
+	File int
+}
+
+// decodes compressed source map (s:l:f:j;...), empty fields are the same as
+// in the previous entry
+func parseSourceMap(sourceMap string) []sourceRange {
+	if sourceMap == "" {
+		return nil
+	}
+	entries := strings.Split(sourceMap, ";")
+	res := make([]sourceRange, 0, len(entries))
+	current := sourceRange{File: -1}
+	for _, entry := range entries {
+		for i, field := range strings.Split(entry, ":") {
+			value, err := strconv.Atoi(field)
+			if err != nil {
+				continue
+			}
+			switch i {
+			case 0:
+				current.Offset = value
+			case 1:
+				current.Length = value
+			case 2:
+				current.File = value
+			}
+		}
+		res = append(res, current)
+	}
+	return res
+}
+
+// returns source files of project by their index, only files that define
+// some contract (and whose content is known) are present
+func getSourceFiles(metadata string) map[sourceKey]*sourceFile {
+	if sourceFiles == nil {
+		sourceFiles = make(map[string]map[sourceKey]*sourceFile)
+	}
+	if sourceFiles[metadata] != nil {
+		return sourceFiles[metadata]
+	}
+	sourceFiles[metadata] = make(map[sourceKey]*sourceFile)
+	for _, artifact := range GetArtifacts(metadata) {
+		if artifact.SourceID < 0 || artifact.Source == "" {
+			continue
+		}
+		key := sourceKey{Compilation: artifact.Compilation, ID: artifact.SourceID}
+		if sourceFiles[metadata][key] == nil {
+			sourceFiles[metadata][key] = newSourceFile(artifact.SourcePath, artifact.Source)
+		}
+	}
+	return sourceFiles[metadata]
+}
+
+// source location of instruction of runtime code
+type instructionSource struct {
+	Pc   uint64
+	Op   string
+	File *sourceFile
+	// range of instruction in the file
+	Offset int
+	Length int
+}
+
+// returns source locations of instructions in runtime code of contract,
+// instructions without known source file are omitted (nil if contract has
+// no source map)
+func getContractSources(metadata string, contract string) []*instructionSource {
+	artifact := getArtifact(metadata, contract)
+	if artifact == nil {
+		return nil
+	}
+	ranges := parseSourceMap(artifact.DeployedSourceMap)
+	if len(ranges) == 0 {
+		return nil
+	}
+	files := getSourceFiles(metadata)
+	var res []*instructionSource
+	// entries of source map correspond to instructions
+	it := asm.NewInstructionIterator(getRuntimeCode(metadata, contract))
+	for idx := 0; it.Next() && idx < len(ranges); idx++ {
+		file := files[sourceKey{Compilation: artifact.Compilation, ID: ranges[idx].File}]
+		if file == nil || ranges[idx].Offset >= len(file.Content) {
+			continue
+		}
+		res = append(res, &instructionSource{
+			Pc:     it.PC(),
+			Op:     it.Op().String(),
+			File:   file,
+			Offset: ranges[idx].Offset,
+			Length: ranges[idx].Length,
+		})
+	}
+	return res
+}
+
+// at most this many lines of source are included in snippets
+const maxSnippetLines = 5
+
+// location of opcode in sources, lines and columns start at 1
+type SourceLocation struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"endLine"`
+	EndColumn int    `json:"endColumn"`
+	// lines of source spanned by the location
+	Snippet string `json:"snippet"`
+}
+
+func (l *SourceLocation) String() string {
+	return fmt.Sprintf("%v:%v:%v", l.File, l.Line, l.Column)
+}
+
+func newSourceLocation(instruction *instructionSource) *SourceLocation {
+	file := instruction.File
+	end := instruction.Offset + instruction.Length
+	if end > len(file.Content) {
+		end = len(file.Content)
+	}
+	location := &SourceLocation{File: file.Path}
+	location.Line, location.Column = file.Position(instruction.Offset)
+	location.EndLine, location.EndColumn = file.Position(end)
+	lines := file.Lines()
+	last := location.EndLine
+	if last > len(lines) {
+		last = len(lines)
+	}
+	if last >= location.Line+maxSnippetLines {
+		last = location.Line + maxSnippetLines - 1
+	}
+	location.Snippet = strings.Join(lines[location.Line-1:last], "\n")
+	return location
+}
+
+// returns location in sources of opcode executed by contract at address,
+// nil if contract is unknown or has no source map. Code of contract destroyed
+// by the sequence is taken from the snapshot, contract that was also created
+// by the sequence is identified by the given contract name.
+func (b *Backend) SourceLocation(location *Location, contract string) *SourceLocation {
+	if location == nil {
+		return nil
+	}
+	code := b.StateDB.GetCode(location.Address)
+	if len(code) == 0 && b.snapshot != nil {
+		code = b.snapshot.GetCode(location.Address)
+	}
+	if len(code) > 0 {
+		name, found := GetContractNameByCode(code, b.Metadata)
+		if !found {
+			return nil
+		}
+		contract = name
+	}
+	for _, instruction := range getContractSources(b.Metadata, contract) {
+		if instruction.Pc == location.Pc {
+			return newSourceLocation(instruction)
+		}
+	}
+	return nil
+}
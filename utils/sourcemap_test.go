@@ -0,0 +1,75 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSourceMap(t *testing.T) {
+	tests := []struct {
+		name      string
+		sourceMap string
+		want      []sourceRange
+	}{
+		{"empty", "", nil},
+		{"single entry", "1:2:0", []sourceRange{{1, 2, 0}}},
+		{
+			// empty entries and fields are inherited from the previous entry
+			"inherited entries",
+			"10:5:0:-;;20;:7;::1",
+			[]sourceRange{{10, 5, 0}, {10, 5, 0}, {20, 5, 0}, {20, 7, 0}, {20, 7, 1}},
+		},
+		{
+			// jump type and modifier depth are ignored
+			"jump and modifier depth",
+			"10:5:0:i:0;;:3::o;;:::-:1",
+			[]sourceRange{{10, 5, 0}, {10, 5, 0}, {10, 3, 0}, {10, 3, 0}, {10, 3, 0}},
+		},
+		{"generated code", "10:5:0;0:0:-1;;4:2:0", []sourceRange{{10, 5, 0}, {0, 0, -1}, {0, 0, -1}, {4, 2, 0}}},
+		{"first entry without file", ":;1:1", []sourceRange{{0, 0, -1}, {1, 1, -1}}},
+	}
+	for _, test := range tests {
+		if got := parseSourceMap(test.sourceMap); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSourceLocation(t *testing.T) {
+	file := newSourceFile("contracts/A.sol", "contract A {\n    function f() public {\n        x = 1;\n    }\n}\n")
+	location := newSourceLocation(&instructionSource{File: file, Offset: 47, Length: 5})
+	want := SourceLocation{
+		File: "contracts/A.sol", Line: 3, Column: 9, EndLine: 3, EndColumn: 14,
+		Snippet: "        x = 1;",
+	}
+	if *location != want {
+		t.Errorf("got %+v, want %+v", *location, want)
+	}
+	// snippet of long range has only the first lines
+	file = newSourceFile("contracts/B.sol", "1\n2\n3\n4\n5\n6\n7\n")
+	location = newSourceLocation(&instructionSource{File: file, Offset: 2, Length: 10})
+	if location.Line != 2 || location.EndLine != 7 || location.Snippet != "2\n3\n4\n5\n6" {
+		t.Errorf("got %+v", *location)
+	}
+}
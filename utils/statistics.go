@@ -92,3 +92,14 @@ func (s *Stats) GetStats() map[string]map[string]string {
 	}
 	return res
 }
+
+// adds statistics of other worker
+func (s *Stats) Merge(other *Stats) {
+	for contract, contractStats := range other.statsMap {
+		for method, pair := range contractStats {
+			s.setIfNil(contract, method)
+			s.statsMap[contract][method][0] = s.statsMap[contract][method][0] + pair[0]
+			s.statsMap[contract][method][1] = s.statsMap[contract][method][1] + pair[1]
+		}
+	}
+}
@@ -28,7 +28,6 @@ import (
 	"fmt"
 	"math/big"
 	"os"
-	"strconv"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -36,6 +35,9 @@ import (
 
 const NullAddress = "0000000000000000000000000000000000000000"
 
+// maximal length of a line in transactions file (deployment payloads are long)
+const maxTxLineSize = 64 * 1024 * 1024
+
 type transaction struct {
 	Hash             string `json:"hash"`
 	AccountNonce     uint64 `json:"nonce"`
@@ -73,14 +75,14 @@ func isContractCreation(t *transaction) bool {
 // converts transaction from format that was written as JSON file
 // to types.Transaction structure and signs with respective key
 func convertAndSign(t *transaction) *types.Transaction {
-	// convert amount and gasprice from hex to decimal
-	amount, err := strconv.ParseInt(t.Amount, 10, 64)
-	if err != nil {
-		amount = 0
+	// amount and gasprice are decimal strings
+	amount, ok := new(big.Int).SetString(t.Amount, 10)
+	if !ok {
+		amount = new(big.Int)
 	}
-	price, err := strconv.ParseInt(t.Price, 10, 64)
-	if err != nil {
-		price = 0
+	price, ok := new(big.Int).SetString(t.Price, 10)
+	if !ok {
+		price = new(big.Int)
 	}
 	payload := getPayload(&t.Payload)
 	var tx *types.Transaction
@@ -88,9 +90,9 @@ func convertAndSign(t *transaction) *types.Transaction {
 		t.GasLimit = uint64(*maxGasPool)
 	}
 	if isContractCreation(t) {
-		tx = types.NewContractCreation(t.AccountNonce, big.NewInt(amount), t.GasLimit, big.NewInt(price), payload)
+		tx = types.NewContractCreation(t.AccountNonce, amount, t.GasLimit, price, payload)
 	} else {
-		tx = types.NewTransaction(t.AccountNonce, common.HexToAddress(t.Recipient), big.NewInt(amount), t.GasLimit, big.NewInt(price), payload)
+		tx = types.NewTransaction(t.AccountNonce, common.HexToAddress(t.Recipient), amount, t.GasLimit, price, payload)
 	}
 	sender := common.HexToAddress(t.From)
 	// mark account as used in order to delete accounts afterwards that weren't
@@ -104,7 +106,27 @@ func convertAndSign(t *transaction) *types.Transaction {
 	return signed
 }
 
-// Reads transactions from json file (that are extracted from Ganache after truffle deploy)
+// converts applied transaction to the format of transactions file
+func newTransactionJSON(tx *types.Transaction, from common.Address,
+	blockHash common.Hash, blockNumber int) *transaction {
+	t := &transaction{
+		Hash:         tx.Hash().Hex(),
+		AccountNonce: tx.Nonce(),
+		BlockHash:    blockHash.Hex(),
+		BlockNumber:  blockNumber,
+		From:         from.Hex(),
+		Amount:       tx.Value().String(),
+		GasLimit:     tx.Gas(),
+		Price:        tx.GasPrice().String(),
+		Payload:      fmt.Sprintf("0x%x", tx.Data()),
+	}
+	if tx.To() != nil {
+		t.Recipient = tx.To().Hex()
+	}
+	return t
+}
+
+// Reads transactions from json file (recorded by serve-rpc command during deployment)
 // and returns array of transaction objects
 func ReadTransactions(txFile string) []*types.Transaction {
 	txJSON, err := os.Open(txFile)
@@ -115,6 +137,7 @@ func ReadTransactions(txFile string) []*types.Transaction {
 
 	var txs []*types.Transaction
 	fileScanner := bufio.NewScanner(txJSON)
+	fileScanner.Buffer(nil, maxTxLineSize)
 	for fileScanner.Scan() {
 		t := transaction{}
 		err := json.Unmarshal([]byte(fileScanner.Text()), &t)
@@ -123,7 +146,7 @@ func ReadTransactions(txFile string) []*types.Transaction {
 		}
 		txs = append(txs, convertAndSign(&t))
 	}
-	// scanner can't read lines longer than 65536 characters
+	// scanner can't read lines longer than maxTxLineSize characters
 	if err := fileScanner.Err(); err != nil {
 		panic(err)
 	}
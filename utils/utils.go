@@ -31,9 +31,12 @@ import (
 
 type MetadataJSON struct {
 	TruffleDir   string `json:"tuffleProjDir"`
+	ProjectDir   string `json:"projectDir"`
 	Transactions string `json:"transactions"`
 	AccountsFile string `json:"accounts"`
 	ConfigFile   string `json:"config"`
+	// truffle (default), hardhat or foundry
+	Framework string `json:"framework"`
 }
 
 var metadataJSONMap map[string]*MetadataJSON
@@ -66,16 +69,31 @@ func getAccountsJSONFile(metadata string) string {
 	return meta.AccountsFile
 }
 
-// reads truffle directory from metadata json
-func getTruffleDir(metadata string) string {
+// reads project directory from metadata json
+func getProjectDir(metadata string) string {
 	meta := getMeta(metadata)
+	if meta.ProjectDir != "" {
+		return meta.ProjectDir
+	}
 	return meta.TruffleDir
 }
 
+// reads framework used to compile the project from metadata json
+func getFramework(metadata string) string {
+	meta := getMeta(metadata)
+	if meta.Framework == "" {
+		return "truffle"
+	}
+	return meta.Framework
+}
+
 type ContractConfig struct {
 	IgnoreAll        bool     `json:"ignore_all"`
 	IgnoredFunctions []string `json:"ignore"`
 	Timestamps       []uint64 `json:"timestamps"`
+	// methods meant to be called by anyone, they are not checked for
+	// changes of privileged state
+	PublicFunctions []string `json:"public"`
 }
 
 var fuzzingConfig map[string]ContractConfig
@@ -97,6 +115,17 @@ func GetConfig(metadata string) map[string]ContractConfig {
 	return fuzzingConfig
 }
 
+// checks if method of contract is listed as public in config, fallback
+// function is listed as "fallback"
+func isPublicMethod(metadata string, contract string, method string) bool {
+	for _, public := range GetConfig(metadata)[contract].PublicFunctions {
+		if public == method || (public == "fallback" && method == "") {
+			return true
+		}
+	}
+	return false
+}
+
 func PrettyPrint(i interface{}) string {
 	s, _ := json.MarshalIndent(i, "", "\t")
 	return string(s)
@@ -0,0 +1,320 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync"
+
+	"fuzzer/argpool"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Fuzzing worker, each worker fuzzes its own copy of deployed contracts with
+// its own arg pool, corpus and results, so workers can run in parallel
+type Worker struct {
+	ID      int
+	Backend *Backend
+	ArgPool *argpool.ArgPool
+	Corpus  *Corpus
+	Result  ResultsMap
+	// number of own corpus entries that were published to the exchange
+	published int
+	// number of exchanged entries and values that were already imported
+	importedEntries int
+	importedValues  int
+}
+
+// Creates worker and replays deployment transactions on its backend. Project
+// data (ABIs, artifacts, accounts, config...) is cached in global maps when it
+// is used first, so workers have to be created before fuzzing starts, after
+// that workers only read the caches.
+func NewWorker(id int, metadata string, seed int64, startTime int64) *Worker {
+	argPool := argpool.NewArgPool(rand.New(rand.NewSource(seed)), startTime)
+	backend := NewBackend(metadata, argPool)
+	// deployment transactions are not counted
+	backend.TxCount = 0
+	// contracts created during fuzzing are identified by their code
+	ReadContractsHashes(metadata)
+	// and their opcodes are located in sources when findings are saved
+	getSourceFiles(metadata)
+	SnapshotBackend(backend)
+	return &Worker{
+		ID:      id,
+		Backend: backend,
+		ArgPool: argPool,
+		Corpus:  NewCorpus(),
+		Result:  make(ResultsMap),
+	}
+}
+
+type exchangedEntry struct {
+	worker int
+	txs    []*LastTxInput
+}
+
+type exchangedValue struct {
+	worker int
+	value  interface{}
+}
+
+// Corpus entries and arg pool values found by workers, each worker publishes
+// what it found and imports what was found by others. Exchange is accessed by
+// all workers concurrently.
+type Exchange struct {
+	mu      sync.Mutex
+	entries []exchangedEntry
+	values  []exchangedValue
+	// number of transactions executed by all workers
+	txCount int
+	// set when some worker found violation that terminates fuzzing
+	stopped bool
+	// number of workers and exchanged entries and values imported by each of
+	// them, entries and values imported by all workers are dropped
+	workers  int
+	imported map[int][2]int
+	// number of entries and values dropped from the start of buffers
+	droppedEntries int
+	droppedValues  int
+	// keys of findings saved by workers
+	findings map[string]bool
+}
+
+func NewExchange(workers int) *Exchange {
+	return &Exchange{
+		workers:  workers,
+		imported: make(map[int][2]int),
+		findings: make(map[string]bool),
+	}
+}
+
+// returns true if no worker saved finding with the key yet, the caller is
+// expected to save it
+func (e *Exchange) ClaimFinding(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.findings[key] {
+		return false
+	}
+	e.findings[key] = true
+	return true
+}
+
+// adds transactions executed by worker, returns total number of transactions
+func (e *Exchange) AddTxCount(n int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.txCount = e.txCount + n
+	return e.txCount
+}
+
+func (e *Exchange) TxCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.txCount
+}
+
+func (e *Exchange) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+}
+
+func (e *Exchange) Stopped() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stopped
+}
+
+// returns copy of input, that doesn't share mutable values with the original
+func copySharedInput(input *LastTxInput) *LastTxInput {
+	cpy := *input
+	if input.Input != nil {
+		args := copyArgs(*input.Input)
+		cpy.Input = &args
+	}
+	if input.Ether != nil {
+		cpy.Ether = new(big.Int).Set(input.Ether)
+	}
+	if input.Timestamp != nil {
+		cpy.Timestamp = new(big.Int).Set(input.Timestamp)
+	}
+	return &cpy
+}
+
+func copySharedEntry(txs []*LastTxInput) []*LastTxInput {
+	entry := make([]*LastTxInput, len(txs))
+	for i, input := range txs {
+		entry[i] = copySharedInput(input)
+	}
+	return entry
+}
+
+// publishes corpus entries and arg pool values found since the last exchange
+// and imports those that were found by other workers
+func (w *Worker) Sync(e *Exchange) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, txs := range w.Corpus.Entries[w.published:] {
+		e.entries = append(e.entries, exchangedEntry{worker: w.ID, txs: copySharedEntry(txs)})
+	}
+	for _, value := range w.ArgPool.TakeAdded() {
+		e.values = append(e.values, exchangedValue{worker: w.ID, value: value})
+	}
+
+	imported := 0
+	for _, entry := range e.entries[w.importedEntries-e.droppedEntries:] {
+		if entry.worker != w.ID {
+			w.Corpus.Add(copySharedEntry(entry.txs))
+			imported++
+		}
+	}
+	w.importedEntries = e.droppedEntries + len(e.entries)
+	// imported entries are not published again
+	w.published = w.Corpus.Size()
+
+	for _, value := range e.values[w.importedValues-e.droppedValues:] {
+		if value.worker != w.ID {
+			w.ArgPool.AddValue(value.value)
+		}
+	}
+	w.importedValues = e.droppedValues + len(e.values)
+	log.Debug(fmt.Sprintf("worker %v imported %v corpus entries", w.ID, imported))
+
+	e.imported[w.ID] = [2]int{w.importedEntries, w.importedValues}
+	e.trim()
+}
+
+// drops entries and values that were already imported by all workers, so
+// buffers don't grow during whole campaign
+func (e *Exchange) trim() {
+	if len(e.imported) < e.workers {
+		return
+	}
+	entries, values := -1, -1
+	for _, imported := range e.imported {
+		if entries < 0 || imported[0] < entries {
+			entries = imported[0]
+		}
+		if values < 0 || imported[1] < values {
+			values = imported[1]
+		}
+	}
+	if entries > e.droppedEntries {
+		// copied so dropped entries can be garbage collected
+		e.entries = append([]exchangedEntry(nil), e.entries[entries-e.droppedEntries:]...)
+		e.droppedEntries = entries
+	}
+	if values > e.droppedValues {
+		e.values = append([]exchangedValue(nil), e.values[values-e.droppedValues:]...)
+		e.droppedValues = values
+	}
+}
+
+// adds coverage and contracts of other backend, contracts created during
+// fuzzing may differ between workers
+func (b *Backend) mergeCoverage(other *Backend) {
+	for address, pcs := range other.OpcodeIndices {
+		if b.OpcodeIndices[address] == nil {
+			b.OpcodeIndices[address] = make(map[uint64]bool)
+		}
+		for pc := range pcs {
+			b.OpcodeIndices[address][pc] = true
+		}
+	}
+	for address, edges := range other.EdgeIndices {
+		if b.EdgeIndices[address] == nil {
+			b.EdgeIndices[address] = make(map[Edge]bool)
+		}
+		for edge := range edges {
+			b.EdgeIndices[address][edge] = true
+		}
+	}
+	for address, branches := range other.BranchIndices {
+		if b.BranchIndices[address] == nil {
+			b.BranchIndices[address] = make(map[Branch]bool)
+		}
+		for branch := range branches {
+			b.BranchIndices[address][branch] = true
+		}
+	}
+	for proxy, implementation := range other.Proxies {
+		b.Proxies[proxy] = implementation
+	}
+
+	known := make(map[common.Address]bool)
+	for _, contract := range b.DeployedContracts {
+		for _, address := range contract.Addresses {
+			known[address] = true
+		}
+	}
+	for name, contract := range other.DeployedContracts {
+		for _, address := range contract.Addresses {
+			if known[address] {
+				continue
+			}
+			if b.DeployedContracts[name] == nil {
+				b.DeployedContracts[name] = &Contract{Methods: contract.Methods}
+			}
+			b.DeployedContracts[name].Addresses = append(b.DeployedContracts[name].Addresses, address)
+			known[address] = true
+		}
+	}
+}
+
+// Merges coverage, ether flows, results, findings, statistics and transaction
+// counts of all workers into the first one, should be called after all
+// workers stopped
+func MergeWorkers(workers []*Worker) *Worker {
+	first := workers[0]
+	for _, w := range workers[1:] {
+		first.Backend.mergeCoverage(w.Backend)
+		first.Backend.mergeEtherFlows(w.Backend)
+		first.Backend.Stats.Merge(&w.Backend.Stats)
+		first.Backend.TxCount = first.Backend.TxCount + w.Backend.TxCount
+		for _, finding := range w.Backend.Findings {
+			first.Backend.mergeFinding(finding)
+		}
+		first.Result.merge(w.Result)
+	}
+	return first
+}
+
+// adds results that are not in r yet
+func (r ResultsMap) merge(other ResultsMap) {
+	for contract, fields := range other {
+		if r[contract] == nil {
+			r[contract] = make(map[string]string)
+		}
+		for key, value := range fields {
+			if _, found := r[contract][key]; !found {
+				r[contract][key] = value
+			}
+		}
+	}
+}
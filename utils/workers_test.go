@@ -0,0 +1,109 @@
+/***
+*  
+*  ChainSecurity ChainFuzz - a fast ethereum transaction fuzzer
+*  Copyright (C) 2019 ChainSecurity AG
+*  
+*  This program is free software: you can redistribute it and/or modify
+*  it under the terms of the GNU Affero General Public License as published by
+*  the Free Software Foundation, either version 3 of the License, or
+*  (at your option) any later version.
+*  
+*  This program is distributed in the hope that it will be useful,
+*  but WITHOUT ANY WARRANTY; without even the implied warranty of
+*  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*  GNU Affero General Public License for more details.
+*  
+*  You should have received a copy of the GNU Affero General Public License
+*  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*
+***/
+
+
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"fuzzer/argpool"
+)
+
+type syncStep struct {
+	worker int
+	// number of corpus entries and arg pool values found before sync
+	found int
+}
+
+func TestExchangeSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		steps   []syncStep
+	}{
+		{
+			"two workers",
+			2,
+			[]syncStep{{0, 2}, {1, 1}, {0, 0}, {1, 3}, {0, 1}},
+		},
+		{
+			// nothing can be trimmed before the idle worker imports it
+			"three workers, late first sync",
+			3,
+			[]syncStep{{0, 1}, {1, 2}, {0, 1}, {1, 0}, {0, 2}, {2, 0}, {1, 1}},
+		},
+		{
+			"trim between finds",
+			3,
+			[]syncStep{{0, 1}, {1, 1}, {2, 1}, {0, 1}, {1, 0}, {2, 2}, {0, 0}, {1, 1}, {2, 0}},
+		},
+	}
+	for _, test := range tests {
+		e := NewExchange(test.workers)
+		workers := make([]*Worker, test.workers)
+		for i := range workers {
+			argPool := argpool.NewArgPool(rand.New(rand.NewSource(int64(i))), 0)
+			argPool.RecordAdded = true
+			workers[i] = &Worker{ID: i, Corpus: NewCorpus(), ArgPool: argPool}
+		}
+		var found []int64
+		for _, step := range test.steps {
+			w := workers[step.worker]
+			for n := 0; n < step.found; n++ {
+				value := int64(1000000007*(step.worker+1) + len(found))
+				w.Corpus.Add([]*LastTxInput{{Contract: fmt.Sprint(value)}})
+				w.ArgPool.AddInt64(value)
+				found = append(found, value)
+			}
+			w.Sync(e)
+		}
+		// final round, every worker imports the rest
+		for _, w := range workers {
+			w.Sync(e)
+		}
+
+		for _, w := range workers {
+			counts := make(map[string]int)
+			for _, txs := range w.Corpus.Entries {
+				counts[txs[0].Contract]++
+			}
+			if w.Corpus.Size() != len(found) {
+				t.Errorf("%v: worker %v has %v entries, want %v", test.name, w.ID, w.Corpus.Size(), len(found))
+			}
+			for _, value := range found {
+				if count := counts[fmt.Sprint(value)]; count != 1 {
+					t.Errorf("%v: worker %v has entry %v %v times", test.name, w.ID, value, count)
+				}
+				if !w.ArgPool.Int64Pool.Contains(value) {
+					t.Errorf("%v: worker %v misses value %v", test.name, w.ID, value)
+				}
+			}
+			if added := w.ArgPool.TakeAdded(); len(added) != 0 {
+				t.Errorf("%v: worker %v recorded imported values %v", test.name, w.ID, added)
+			}
+		}
+		if len(e.entries) != 0 || len(e.values) != 0 {
+			t.Errorf("%v: %v entries and %v values not trimmed", test.name, len(e.entries), len(e.values))
+		}
+	}
+}